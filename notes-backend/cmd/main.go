@@ -1,17 +1,30 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
 	"notes-backend/internal/auth"
 	"notes-backend/internal/categories"
+	"notes-backend/internal/collab"
+	"notes-backend/internal/events"
 	"notes-backend/internal/images"
+	"notes-backend/internal/importer"
 	"notes-backend/internal/logs"
+	"notes-backend/internal/mailer"
+	"notes-backend/internal/markdown"
 	"notes-backend/internal/middleware"
 	"notes-backend/internal/notes"
+	"notes-backend/internal/public"
+	"notes-backend/internal/sharing"
+	"notes-backend/internal/tags"
+	"notes-backend/internal/users"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -28,6 +41,62 @@ func migrate(db *sql.DB) error {
 			password_hash TEXT NOT NULL,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_failures INT NOT NULL DEFAULT 0`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_locked_until TIMESTAMP`,
+		`CREATE TABLE IF NOT EXISTS recovery_codes (
+			id SERIAL PRIMARY KEY,
+			user_id INT REFERENCES users(id) ON DELETE CASCADE,
+			code_hash TEXT NOT NULL,
+			used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes(user_id)`,
+		`CREATE TABLE IF NOT EXISTS login_challenges (
+			token_hash TEXT PRIMARY KEY,
+			user_id INT REFERENCES users(id) ON DELETE CASCADE,
+			attempts INT NOT NULL DEFAULT 0,
+			expires_at TIMESTAMP NOT NULL
+		)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP`,
+		`CREATE TABLE IF NOT EXISTS email_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INT REFERENCES users(id) ON DELETE CASCADE,
+			purpose TEXT NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			email TEXT NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_email_tokens_user_id ON email_tokens(user_id)`,
+		`CREATE TABLE IF NOT EXISTS user_identities (
+			id SERIAL PRIMARY KEY,
+			user_id INT REFERENCES users(id) ON DELETE CASCADE,
+			issuer TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			email TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (issuer, subject)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities(user_id)`,
+		`CREATE TABLE IF NOT EXISTS oidc_states (
+			state_hash TEXT PRIMARY KEY,
+			nonce TEXT NOT NULL,
+			code_verifier TEXT NOT NULL,
+			link_user_id INT REFERENCES users(id) ON DELETE CASCADE,
+			expires_at TIMESTAMP NOT NULL
+		)`,
+		`ALTER TABLE oidc_states ADD COLUMN IF NOT EXISTS binding_hash TEXT`,
+		`CREATE TABLE IF NOT EXISTS account_exports (
+			token_hash TEXT PRIMARY KEY,
+			path TEXT NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'admin'))`,
 		`CREATE TABLE IF NOT EXISTS categories (
 			id SERIAL PRIMARY KEY,
 			user_id INT REFERENCES users(id) ON DELETE CASCADE,
@@ -46,22 +115,192 @@ func migrate(db *sql.DB) error {
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			deleted_at TIMESTAMP
 		)`,
+		// Full-text search: title hits weigh more (A) than body hits (B)
+		`ALTER TABLE notes ADD COLUMN IF NOT EXISTS search_vector tsvector
+			GENERATED ALWAYS AS (
+				setweight(to_tsvector('english', COALESCE(title, '')), 'A') ||
+				setweight(to_tsvector('english', COALESCE(body, '')), 'B')
+			) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_notes_search_vector ON notes USING GIN (search_vector)`,
+		`ALTER TABLE notes ADD COLUMN IF NOT EXISTS public_slug TEXT UNIQUE`,
+		// Notes made public before slugs existed get one too, in the same
+		// 22-character URL-safe form as newPublicSlug
+		`UPDATE notes
+		SET public_slug = translate(rtrim(encode(uuid_send(gen_random_uuid()), 'base64'), '='), '+/', '-_')
+		WHERE visibility = 'public' AND public_slug IS NULL`,
+		`ALTER TABLE notes ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1`,
+		// Keyset pagination indexes for the default listing orders
+		`CREATE INDEX IF NOT EXISTS idx_notes_user_created ON notes (user_id, created_at DESC, id DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_notes_user_updated ON notes (user_id, updated_at DESC, id DESC)`,
+		`CREATE TABLE IF NOT EXISTS note_revisions (
+			id SERIAL PRIMARY KEY,
+			note_id INT REFERENCES notes(id) ON DELETE CASCADE,
+			revision INT NOT NULL,
+			title TEXT NOT NULL,
+			body TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (note_id, revision)
+		)`,
+		`CREATE TABLE IF NOT EXISTS tags (
+			id SERIAL PRIMARY KEY,
+			user_id INT REFERENCES users(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, name)
+		)`,
+		`CREATE TABLE IF NOT EXISTS note_tags (
+			note_id INT REFERENCES notes(id) ON DELETE CASCADE,
+			tag_id INT REFERENCES tags(id) ON DELETE CASCADE,
+			PRIMARY KEY (note_id, tag_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_note_tags_tag_id ON note_tags (tag_id)`,
+		`CREATE TABLE IF NOT EXISTS note_shares (
+			id SERIAL PRIMARY KEY,
+			note_id INT REFERENCES notes(id) ON DELETE CASCADE,
+			user_id INT REFERENCES users(id) ON DELETE CASCADE,
+			role TEXT CHECK (role IN ('viewer', 'editor')) NOT NULL DEFAULT 'viewer',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (note_id, user_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_note_shares_user_id ON note_shares (user_id)`,
 		`CREATE TABLE IF NOT EXISTS images (
 			id SERIAL PRIMARY KEY,
 			note_id INT REFERENCES notes(id) ON DELETE CASCADE,
 			url TEXT NOT NULL,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
-		`CREATE TABLE IF NOT EXISTS logs (
+		`CREATE TABLE IF NOT EXISTS refresh_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INT REFERENCES users(id) ON DELETE CASCADE,
+			token_hash TEXT NOT NULL UNIQUE,
+			family TEXT NOT NULL,
+			access_jti TEXT,
+			access_expires_at TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens (family)`,
+		`CREATE TABLE IF NOT EXISTS revoked_tokens (
+			jti TEXT PRIMARY KEY,
+			expires_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS stream_tickets (
+			ticket_hash TEXT PRIMARY KEY,
+			user_id INT REFERENCES users(id) ON DELETE CASCADE,
+			access_jti TEXT NOT NULL,
+			access_expires_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS personal_access_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INT REFERENCES users(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			prefix TEXT NOT NULL,
+			scopes TEXT[] NOT NULL,
+			expires_at TIMESTAMP,
+			last_used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_personal_access_tokens_user_id ON personal_access_tokens (user_id)`,
+		`CREATE TABLE IF NOT EXISTS events (
+			id BIGSERIAL PRIMARY KEY,
+			user_id INT REFERENCES users(id) ON DELETE CASCADE,
+			entity TEXT NOT NULL,
+			entity_id INT NOT NULL,
+			note_id INT,
+			action TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_events_user_id ON events (user_id, id)`,
+		`CREATE TABLE IF NOT EXISTS import_jobs (
 			id SERIAL PRIMARY KEY,
+			user_id INT REFERENCES users(id) ON DELETE CASCADE,
+			format TEXT NOT NULL,
+			status TEXT CHECK (status IN ('queued', 'running', 'done', 'failed')) DEFAULT 'queued',
+			total INT NOT NULL DEFAULT 0,
+			processed INT NOT NULL DEFAULT 0,
+			imported INT NOT NULL DEFAULT 0,
+			errors JSONB NOT NULL DEFAULT '[]',
+			error TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			finished_at TIMESTAMP
+		)`,
+		`ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS upload_path TEXT`,
+		// Jobs from before heartbeats count as stale
+		`ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS heartbeat_at TIMESTAMP NOT NULL DEFAULT 'epoch'`,
+		// uploads/ is no longer served; point note bodies at the image
+		// route instead (see images.URL). Links are host-relative, and ones
+		// already written with a host get it removed.
+		`DO $$
+		DECLARE
+			i RECORD;
+			link TEXT;
+			absolute TEXT;
+		BEGIN
+			FOR i IN SELECT id, note_id, url FROM images LOOP
+				link := '/notes/' || i.note_id || '/images/' || i.id;
+				absolute := 'https?://[^/\s()]+' || link || '(?!\d)';
+				UPDATE notes SET body = regexp_replace(replace(body, i.url, link), absolute, link, 'g')
+				WHERE id = i.note_id AND (strpos(body, i.url) > 0 OR body ~ absolute);
+				UPDATE note_revisions SET body = regexp_replace(replace(body, i.url, link), absolute, link, 'g')
+				WHERE note_id = i.note_id AND (strpos(body, i.url) > 0 OR body ~ absolute);
+			END LOOP;
+		END $$`,
+		// logs is partitioned by month (see internal/logs/retention.go). A
+		// plain logs table from before is set aside here and moved into the
+		// partitions below, keeping its ids.
+		`DO $$
+		BEGIN
+			IF EXISTS (SELECT 1 FROM pg_class WHERE oid = to_regclass('logs') AND relkind = 'r') THEN
+				ALTER TABLE logs ADD COLUMN IF NOT EXISTS user_id INT;
+				ALTER TABLE logs ADD COLUMN IF NOT EXISTS request_id TEXT;
+				ALTER TABLE logs RENAME TO logs_unpartitioned;
+				ALTER TABLE logs_unpartitioned RENAME CONSTRAINT logs_pkey TO logs_unpartitioned_pkey;
+				ALTER TABLE logs_unpartitioned ALTER COLUMN id DROP DEFAULT;
+				ALTER SEQUENCE logs_id_seq OWNED BY NONE;
+				ALTER SEQUENCE logs_id_seq AS BIGINT;
+				DROP INDEX IF EXISTS idx_logs_created_at, idx_logs_user_id, idx_logs_request_id;
+			END IF;
+		END $$`,
+		`CREATE SEQUENCE IF NOT EXISTS logs_id_seq AS BIGINT`,
+		`CREATE TABLE IF NOT EXISTS logs (
+			id BIGINT NOT NULL DEFAULT nextval('logs_id_seq'),
 			method TEXT NOT NULL,
 			endpoint TEXT NOT NULL,
 			request_headers JSONB,
 			request_body JSONB,
 			response_body JSONB,
 			status_code INT,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)`,
+			user_id INT,
+			request_id TEXT,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (created_at, id)
+		) PARTITION BY RANGE (created_at)`,
+		// For logs tables partitioned before request IDs were recorded
+		`ALTER TABLE logs ADD COLUMN IF NOT EXISTS request_id TEXT`,
+		`ALTER SEQUENCE logs_id_seq OWNED BY logs.id`,
+		`CREATE INDEX IF NOT EXISTS idx_logs_id ON logs(id)`,
+		`CREATE INDEX IF NOT EXISTS idx_logs_user_id ON logs(user_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_logs_request_id ON logs(request_id)`,
+		`DO $$
+		DECLARE
+			m TIMESTAMP;
+		BEGIN
+			IF to_regclass('logs_unpartitioned') IS NULL THEN
+				RETURN;
+			END IF;
+			FOR m IN SELECT DISTINCT date_trunc('month', created_at) FROM logs_unpartitioned WHERE created_at IS NOT NULL LOOP
+				EXECUTE format('CREATE TABLE IF NOT EXISTS %I PARTITION OF logs FOR VALUES FROM (%L) TO (%L)',
+					to_char(m, '"logs_"YYYY_MM'), m, m + INTERVAL '1 month');
+			END LOOP;
+			INSERT INTO logs (id, method, endpoint, request_headers, request_body, response_body, status_code, user_id, request_id, created_at)
+			SELECT id, method, endpoint, request_headers, request_body, response_body, status_code, user_id, request_id, created_at
+			FROM logs_unpartitioned WHERE created_at IS NOT NULL;
+			DROP TABLE logs_unpartitioned;
+		END $$`,
 	}
 
 	for _, q := range queries {
@@ -73,50 +312,161 @@ func migrate(db *sql.DB) error {
 	return nil
 }
 
-func setupRouter(db *sql.DB) *gin.Engine {
-	r := gin.Default()
+func setupRouter(db *sql.DB, hub *events.Hub, mail mailer.Mailer, sso *auth.OIDCProvider, logWriter *logs.Writer, logPolicy *logs.Policy) *gin.Engine {
+	// gin.Default without its logger, which would print stream tickets
+	r := gin.New()
+	r.Use(middleware.ConsoleLogger(), gin.Recovery())
+
+	// Every request gets an ID first, so responses, logs and errors share it
+	r.Use(middleware.RequestID())
+
+	// The frontend; live editing sockets only accept these origins too
+	allowedOrigins := []string{"http://localhost:3000"}
 
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000"},
+		AllowOrigins:     allowedOrigins,
 		AllowMethods:     []string{"POST", "GET", "OPTIONS", "PATCH", "DELETE"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "If-Match", "If-None-Match", "X-Request-ID"},
+		ExposeHeaders:    []string{"Content-Length", "ETag", "X-Request-ID"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
 	// ✅ Apply logging middleware early (so all routes get logged)
-	r.Use(middleware.LoggingMiddleware(db))
+	r.Use(middleware.LoggingMiddleware(logWriter, logPolicy))
 
 	// Public routes
-	r.Static("/uploads", "./uploads")
 	r.GET("/health", func(c *gin.Context) { c.JSON(200, gin.H{"status": "ok"}) })
-	r.POST("/register", auth.RegisterHandler(db))
+	r.POST("/register", auth.RegisterHandler(db, mail))
 	r.POST("/login", auth.LoginHandler(db))
-	r.GET("/logs", logs.ListLogsHandler(db))
+	r.POST("/login/2fa", auth.TwoFactorLoginHandler(db))
+	r.POST("/token/refresh", auth.RefreshTokenHandler(db))
+	r.POST("/email/verify", auth.VerifyEmailHandler(db))
+	r.POST("/email/verify/resend", middleware.JWTMiddleware(db), middleware.SessionOnly(), auth.ResendVerificationHandler(db, mail))
+	r.POST("/password/forgot", auth.ForgotPasswordHandler(db, mail))
+	r.POST("/password/reset", auth.ResetPasswordHandler(db))
+	r.GET("/auth/oidc/login", auth.OIDCLoginHandler(db, sso))
+	r.GET("/auth/oidc/callback", auth.OIDCCallbackHandler(db, sso))
+	r.POST("/account-exports/download", users.DownloadExportHandler(db))
+	r.GET("/.well-known/jwks.json", auth.JWKSHandler())
+	r.POST("/logout", middleware.JWTMiddleware(db), middleware.SessionOnly(), auth.LogoutHandler(db))
+	r.POST("/logout-all", middleware.JWTMiddleware(db), middleware.SessionOnly(), auth.LogoutAllHandler(db))
+	r.POST("/stream-tickets", middleware.JWTMiddleware(db), middleware.SessionOnly(), auth.CreateStreamTicketHandler(db))
+	r.GET("/events", middleware.StreamAuthMiddleware(db),
+		middleware.RequireScope(auth.ScopeNotesRead), events.StreamHandler(hub))
+	// Joining lets the client edit, so it takes the write scope
+	r.GET("/notes/:id/live", middleware.StreamAuthMiddleware(db),
+		middleware.RequireScope(auth.ScopeNotesWrite), collab.LiveHandler(db, collab.NewManager(db), allowedOrigins))
+	r.GET("/p/:slug", public.GetPublicNoteHandler(db))
+	r.GET("/p/:slug/images/:image_id", public.GetPublicImageHandler(db))
 
 	// Protected routes
 	notesGroup := r.Group("/notes")
-	notesGroup.Use(middleware.JWTMiddleware())
+	notesGroup.Use(middleware.JWTMiddleware(db), middleware.RequireScopes(auth.ScopeNotesRead, auth.ScopeNotesWrite))
 	{
 		notesGroup.POST("", notes.CreateNoteHandler(db))
 		notesGroup.GET("", notes.ListNotesHandler(db))
+		notesGroup.GET("/trash", notes.ListTrashHandler(db))
+		notesGroup.GET("/shared-with-me", notes.ListSharedWithMeHandler(db))
 		notesGroup.GET("/:id", notes.GetNoteByIDHandler(db))
 		notesGroup.PATCH("/:id", notes.UpdateNoteHandler(db))
 		notesGroup.DELETE("/:id", notes.DeleteNoteHandler(db))
-		notesGroup.POST("/:id/images", images.UploadImageHandler(db))
-		notesGroup.GET("/:id/images", images.ListImagesHandler(db))
-		notesGroup.DELETE("/:id/images/:image_id", images.DeleteImageHandler(db))
+		notesGroup.POST("/:id/restore", notes.RestoreNoteHandler(db))
+		notesGroup.DELETE("/:id/purge", notes.PurgeNoteHandler(db))
+		notesGroup.GET("/:id/revisions", notes.ListRevisionsHandler(db))
+		notesGroup.GET("/:id/revisions/:rev", notes.GetRevisionHandler(db))
+		notesGroup.POST("/:id/revisions/:rev/restore", notes.RestoreRevisionHandler(db))
+		notesGroup.GET("/:id/diff", notes.DiffRevisionsHandler(db))
+		notesGroup.POST("/:id/shares", sharing.GrantShareHandler(db))
+		notesGroup.GET("/:id/shares", sharing.ListSharesHandler(db))
+		notesGroup.DELETE("/:id/shares/:user_id", sharing.RevokeShareHandler(db))
+	}
+
+	imagesGroup := r.Group("/notes/:id/images")
+	imagesGroup.Use(middleware.JWTMiddleware(db), middleware.RequireScopes(auth.ScopeNotesRead, auth.ScopeImagesWrite))
+	{
+		imagesGroup.POST("", images.UploadImageHandler(db))
+		imagesGroup.GET("", images.ListImagesHandler(db))
+		imagesGroup.GET("/:image_id", images.GetImageHandler(db))
+		imagesGroup.DELETE("/:image_id", images.DeleteImageHandler(db))
 	}
 
 	categoriesGroup := r.Group("/categories")
-	categoriesGroup.Use(middleware.JWTMiddleware())
+	categoriesGroup.Use(middleware.JWTMiddleware(db), middleware.RequireScopes(auth.ScopeNotesRead, auth.ScopeNotesWrite))
 	{
 		categoriesGroup.POST("", categories.CreateCategoryHandler(db))
 		categoriesGroup.GET("", categories.ListCategoriesHandler(db))
 		categoriesGroup.DELETE("/:id", categories.DeleteCategoryHandler(db))
 	}
 
+	transferGroup := r.Group("")
+	transferGroup.Use(middleware.JWTMiddleware(db), middleware.RequireScopes(auth.ScopeNotesRead, auth.ScopeNotesWrite))
+	{
+		transferGroup.GET("/export", markdown.ExportHandler(db))
+		transferGroup.POST("/import", markdown.ImportHandler(db))
+		transferGroup.POST("/imports", importer.StartImportHandler(db))
+		transferGroup.GET("/imports/:id", importer.GetImportHandler(db))
+	}
+
+	tagsGroup := r.Group("/tags")
+	tagsGroup.Use(middleware.JWTMiddleware(db), middleware.RequireScopes(auth.ScopeNotesRead, auth.ScopeNotesWrite))
+	{
+		tagsGroup.POST("", tags.CreateTagHandler(db))
+		tagsGroup.GET("", tags.ListTagsHandler(db))
+		tagsGroup.PATCH("/:id", tags.RenameTagHandler(db))
+		tagsGroup.DELETE("/:id", tags.DeleteTagHandler(db))
+	}
+
+	syncGroup := r.Group("/sync")
+	syncGroup.Use(middleware.JWTMiddleware(db), middleware.RequireScopes(auth.ScopeNotesRead, auth.ScopeNotesWrite))
+	{
+		syncGroup.GET("", notes.GetSyncHandler(db))
+		syncGroup.POST("", notes.ApplySyncHandler(db))
+	}
+
+	tokensGroup := r.Group("/tokens")
+	tokensGroup.Use(middleware.JWTMiddleware(db), middleware.SessionOnly())
+	{
+		tokensGroup.POST("", auth.CreatePersonalTokenHandler(db))
+		tokensGroup.GET("", auth.ListPersonalTokensHandler(db))
+		tokensGroup.DELETE("/:id", auth.DeletePersonalTokenHandler(db))
+	}
+
+	twoFactorGroup := r.Group("/2fa")
+	twoFactorGroup.Use(middleware.JWTMiddleware(db), middleware.SessionOnly())
+	{
+		twoFactorGroup.POST("/setup", auth.SetupTOTPHandler(db))
+		twoFactorGroup.POST("/confirm", auth.ConfirmTOTPHandler(db))
+		twoFactorGroup.POST("/recovery-codes", auth.RegenerateRecoveryCodesHandler(db))
+		twoFactorGroup.POST("/disable", auth.DisableTOTPHandler(db))
+	}
+
+	// Logs hold other users' requests and note contents
+	logsGroup := r.Group("/logs")
+	logsGroup.Use(middleware.JWTMiddleware(db), middleware.SessionOnly(), middleware.RequireAdmin(db))
+	{
+		logsGroup.GET("", logs.ListLogsHandler(db))
+		logsGroup.GET("/stats", logs.StatsHandler(logWriter))
+		logsGroup.GET("/:id", logs.GetLogHandler(db))
+	}
+
+	meGroup := r.Group("/me")
+	meGroup.Use(middleware.JWTMiddleware(db), middleware.SessionOnly())
+	{
+		meGroup.GET("", users.GetMeHandler(db))
+		meGroup.PATCH("", users.UpdateMeHandler(db, mail))
+		meGroup.POST("/password", users.ChangePasswordHandler(db))
+		meGroup.DELETE("", users.DeleteMeHandler(db))
+	}
+
+	identitiesGroup := r.Group("/auth/identities")
+	identitiesGroup.Use(middleware.JWTMiddleware(db), middleware.SessionOnly())
+	{
+		identitiesGroup.GET("", auth.ListIdentitiesHandler(db))
+		identitiesGroup.POST("", auth.LinkIdentityHandler(db, sso))
+		identitiesGroup.DELETE("/:id", auth.UnlinkIdentityHandler(db))
+	}
+
 	return r
 }
 
@@ -152,7 +502,86 @@ func main() {
 	}
 	fmt.Println("Database migrated successfully!")
 
-	r := setupRouter(db)
-	fmt.Println("Server running on http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	if err := auth.LoadKeys(); err != nil {
+		log.Fatal("Error loading JWT keys:", err)
+	}
+
+	// Purge notes that sat in the trash longer than the retention period
+	retentionDays := 30
+	if v := os.Getenv("TRASH_RETENTION_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 1 {
+			log.Fatal("TRASH_RETENTION_DAYS must be a positive number of days")
+		}
+		retentionDays = days
+	}
+	notes.StartTrashSweeper(db, time.Duration(retentionDays)*24*time.Hour, time.Hour)
+	auth.StartTokenSweeper(db, time.Hour)
+	users.StartExportSweeper(db, time.Hour)
+	importer.StartJobSweeper(db)
+
+	// Request logs are kept in monthly partitions; whole months are dropped
+	// once they are past the retention period, after archiving if asked to
+	if err := logs.CreatePartitions(db, time.Now()); err != nil {
+		log.Fatal("Error creating log partitions:", err)
+	}
+	logRetentionDays := 90
+	if v := os.Getenv("LOG_RETENTION_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 1 {
+			log.Fatal("LOG_RETENTION_DAYS must be a positive number of days")
+		}
+		logRetentionDays = days
+	}
+	logs.StartRetention(db, time.Duration(logRetentionDays)*24*time.Hour, os.Getenv("LOG_ARCHIVE_DIR"), time.Hour)
+
+	// Change events are fanned out to every instance through LISTEN/NOTIFY
+	hub := events.NewHub(db)
+	if err := hub.Listen(dsn); err != nil {
+		log.Fatal("Error listening for events:", err)
+	}
+
+	mail, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatal("Error configuring mail:", err)
+	}
+
+	sso, err := auth.LoadOIDC(context.Background())
+	if err != nil {
+		log.Fatal("Error configuring OpenID Connect:", err)
+	}
+
+	// Request logs are written in batches by a single worker
+	logWriter := logs.NewWriter(db, 10000, 500, time.Second)
+	logPolicy, err := logs.LoadPolicy()
+	if err != nil {
+		log.Fatal("Error loading log policy:", err)
+	}
+
+	r := setupRouter(db, hub, mail, sso, logWriter, logPolicy)
+	srv := &http.Server{Addr: ":8080", Handler: r}
+	srv.RegisterOnShutdown(hub.CloseStreams)
+
+	go func() {
+		fmt.Println("Server running on http://localhost:8080")
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// On SIGINT/SIGTERM, finish the requests in flight, then write the
+	// logs they left in the queue
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	fmt.Println("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		fmt.Printf("❌ Server shutdown: %v\n", err)
+	}
+	if err := logWriter.Close(shutdownCtx); err != nil {
+		fmt.Printf("❌ Flushing logs: %v\n", err)
+	}
 }
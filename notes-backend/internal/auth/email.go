@@ -0,0 +1,402 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"notes-backend/internal/mailer"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Purposes of the single-use tokens sent by email
+const (
+	purposeVerifyEmail   = "verify_email"
+	purposeChangeEmail   = "change_email"
+	purposePasswordReset = "password_reset"
+)
+
+const (
+	EmailVerificationTTL = 48 * time.Hour
+	PasswordResetTTL     = time.Hour
+	// resetResendInterval throttles reset emails to the same account
+	resetResendInterval = time.Minute
+	minPasswordLen      = 8
+)
+
+var errInvalidEmailToken = errors.New("invalid or expired token")
+
+// isUniqueViolation reports whether err is a Postgres unique constraint error
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// appURL is where the frontend runs; links in emails point there
+func appURL() string {
+	if u := os.Getenv("APP_URL"); u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	return "http://localhost:3000"
+}
+
+// NormalizeEmail validates an address and returns its bare form
+func NormalizeEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil || addr.Name != "" {
+		return "", errors.New("invalid email address")
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+// ValidatePassword enforces the password rules for new passwords
+func ValidatePassword(password string) error {
+	if len(password) < minPasswordLen {
+		return fmt.Errorf("Password must be at least %d characters", minPasswordLen)
+	}
+	return nil
+}
+
+// createEmailToken stores the hash of a new token. It remembers the address
+// it was sent to, so a token can't verify an address the user changed to
+// afterwards.
+func createEmailToken(q execer, userID int, purpose, email string, ttl time.Duration) (string, error) {
+	token, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	_, err = q.Exec(`
+		INSERT INTO email_tokens (user_id, purpose, token_hash, email, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, userID, purpose, HashToken(token), email, time.Now().Add(ttl))
+	return token, err
+}
+
+// consumeEmailToken marks a live token as used and returns who it was for
+func consumeEmailToken(tx *sql.Tx, token, purpose string) (int, string, error) {
+	var userID int
+	var email string
+	err := tx.QueryRow(`
+		UPDATE email_tokens SET used_at=NOW()
+		WHERE token_hash=$1 AND purpose=$2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id, email
+	`, HashToken(token), purpose).Scan(&userID, &email)
+	if err == sql.ErrNoRows {
+		return 0, "", errInvalidEmailToken
+	}
+	return userID, email, err
+}
+
+// SendVerificationEmail mails a link that confirms the user owns email
+func SendVerificationEmail(db *sql.DB, m mailer.Mailer, userID int, email string) error {
+	token, err := createEmailToken(db, userID, purposeVerifyEmail, email, EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	return m.Send(mailer.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body: "Open this link to confirm your email address:\n\n" +
+			appURL() + "/verify-email?token=" + url.QueryEscape(token) + "\n\n" +
+			fmt.Sprintf("The link expires in %d hours.\n", int(EmailVerificationTTL.Hours())),
+	})
+}
+
+// SendEmailChangeEmail mails a link to newEmail that switches the account
+// over to it. Until then the old address stays, so a stolen session can't
+// move the account to an address it controls. Earlier links stop working.
+func SendEmailChangeEmail(db *sql.DB, m mailer.Mailer, userID int, oldEmail, newEmail string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		UPDATE email_tokens SET used_at=NOW()
+		WHERE user_id=$1 AND purpose=$2 AND used_at IS NULL
+	`, userID, purposeChangeEmail); err != nil {
+		return err
+	}
+	token, err := createEmailToken(tx, userID, purposeChangeEmail, newEmail, EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if err := m.Send(mailer.Message{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body: "Open this link to use this address for your account:\n\n" +
+			appURL() + "/verify-email?token=" + url.QueryEscape(token) + "\n\n" +
+			fmt.Sprintf("The link expires in %d hours.\n", int(EmailVerificationTTL.Hours())),
+	}); err != nil {
+		return err
+	}
+	if oldEmail == "" {
+		return nil
+	}
+	return m.Send(mailer.Message{
+		To:      oldEmail,
+		Subject: "Your email address is being changed",
+		Body: "Someone asked to change the email address of your account to " + newEmail + ".\n" +
+			"If it wasn't you, change your password and log out all sessions.\n",
+	})
+}
+
+// VerifyEmailHandler - POST /email/verify
+// Confirms the account's address, or switches to a new one for links sent
+// by SendEmailChangeEmail.
+func VerifyEmailHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Token string `json:"token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		userID, email, err := consumeEmailToken(tx, req.Token, purposeVerifyEmail)
+		if err == errInvalidEmailToken {
+			changeEmail(c, tx, req.Token)
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+			return
+		}
+
+		res, err := tx.Exec(`
+			UPDATE users SET email_verified_at=COALESCE(email_verified_at, NOW())
+			WHERE id=$1 AND lower(email)=lower($2)
+		`, userID, email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The email address has changed since this link was sent"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+	}
+}
+
+// changeEmail finishes an email change with a token sent to the new address
+func changeEmail(c *gin.Context, tx *sql.Tx, token string) {
+	userID, email, err := consumeEmailToken(tx, token, purposeChangeEmail)
+	if err == errInvalidEmailToken {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired token"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	_, err = tx.Exec(`UPDATE users SET email=$1, email_verified_at=NOW() WHERE id=$2`, email, userID)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already taken"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email address changed"})
+}
+
+// ResendVerificationHandler - POST /email/verify/resend
+func ResendVerificationHandler(db *sql.DB, m mailer.Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		var email string
+		var verifiedAt sql.NullTime
+		err := db.QueryRow(`SELECT email, email_verified_at FROM users WHERE id=$1`, userID).Scan(&email, &verifiedAt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+			return
+		}
+		if verifiedAt.Valid {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email is already verified"})
+			return
+		}
+
+		if err := SendVerificationEmail(db, m, userID, email); err != nil {
+			fmt.Printf("❌ Failed to send verification email: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+	}
+}
+
+// ForgotPasswordHandler - POST /password/forgot
+// Answers the same whether or not the address belongs to an account, so it
+// can't be used to find out who has one.
+func ForgotPasswordHandler(db *sql.DB, m mailer.Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Email string `json:"email" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		email, err := NormalizeEmail(req.Email)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+			return
+		}
+
+		sent := gin.H{"message": "If an account exists for that address, a reset link has been sent"}
+
+		var userID int
+		var recent bool
+		err = db.QueryRow(`
+			SELECT u.id, EXISTS (
+				SELECT 1 FROM email_tokens t
+				WHERE t.user_id=u.id AND t.purpose=$2 AND t.used_at IS NULL AND t.created_at > $3
+			)
+			FROM users u WHERE lower(u.email)=$1
+		`, email, purposePasswordReset, time.Now().Add(-resetResendInterval)).Scan(&userID, &recent)
+		if err == sql.ErrNoRows || (err == nil && recent) {
+			c.JSON(http.StatusOK, sent)
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start password reset"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		// Only the newest link works
+		if _, err := tx.Exec(`
+			UPDATE email_tokens SET used_at=NOW()
+			WHERE user_id=$1 AND purpose=$2 AND used_at IS NULL
+		`, userID, purposePasswordReset); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start password reset"})
+			return
+		}
+		token, err := createEmailToken(tx, userID, purposePasswordReset, email, PasswordResetTTL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start password reset"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start password reset"})
+			return
+		}
+
+		// Sent in the background, so neither how long delivery takes nor
+		// whether it fails tells the caller that the account exists
+		msg := mailer.Message{
+			To:      email,
+			Subject: "Reset your password",
+			Body: "Someone asked to reset the password for your account. If it was you, open this link:\n\n" +
+				appURL() + "/reset-password?token=" + url.QueryEscape(token) + "\n\n" +
+				fmt.Sprintf("The link expires in %d minutes. If you didn't ask for it, ignore this email.\n", int(PasswordResetTTL.Minutes())),
+		}
+		go func() {
+			if err := m.Send(msg); err != nil {
+				fmt.Printf("❌ Failed to send password reset email: %v\n", err)
+			}
+		}()
+
+		c.JSON(http.StatusOK, sent)
+	}
+}
+
+// ResetPasswordHandler - POST /password/reset
+// Sets a new password and logs out every session of the account.
+func ResetPasswordHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Token    string `json:"token" binding:"required"`
+			Password string `json:"password" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		if err := ValidatePassword(req.Password); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		userID, email, err := consumeEmailToken(tx, req.Token, purposePasswordReset)
+		if err == errInvalidEmailToken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired token"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+			return
+		}
+
+		// Getting the email proves the address, if it is still the account's
+		if _, err := tx.Exec(`
+			UPDATE users SET password_hash=$1,
+				email_verified_at=CASE WHEN lower(email)=$3 THEN COALESCE(email_verified_at, NOW()) ELSE email_verified_at END
+			WHERE id=$2
+		`, string(hash), userID, email); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+			return
+		}
+		if err := revokeRefreshTokens(tx, `user_id=$2`, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Password has been reset, please log in"})
+	}
+}
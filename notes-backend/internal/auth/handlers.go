@@ -2,14 +2,18 @@ package auth
 
 import (
 	"database/sql"
+	"fmt"
 	"net/http"
 
+	"notes-backend/internal/mailer"
+
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// RegisterHandler handles user registration
-func RegisterHandler(db *sql.DB) gin.HandlerFunc {
+// RegisterHandler handles user registration and sends the email
+// verification link
+func RegisterHandler(db *sql.DB, m mailer.Mailer) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Step 1: Bind JSON from request
 		var req struct {
@@ -22,6 +26,16 @@ func RegisterHandler(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		email, err := NormalizeEmail(req.Email)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+			return
+		}
+		if err := ValidatePassword(req.Password); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		// Step 2: Hash the password
 		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 		if err != nil {
@@ -33,14 +47,24 @@ func RegisterHandler(db *sql.DB) gin.HandlerFunc {
 		var id int
 		err = db.QueryRow(
 			"INSERT INTO users (username, email, password_hash) VALUES ($1, $2, $3) RETURNING id",
-			req.Username, req.Email, string(hash),
+			req.Username, email, string(hash),
 		).Scan(&id)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 			return
 		}
 
-		// Step 4: Respond with success
+		// Step 4: Ask them to confirm the address. The account works either
+		// way; POST /email/verify/resend sends another link. Sent in the
+		// background, like reset mails, so a slow mail server doesn't hold
+		// up the response.
+		go func() {
+			if err := SendVerificationEmail(db, m, id, email); err != nil {
+				fmt.Printf("❌ Failed to send verification email: %v\n", err)
+			}
+		}()
+
+		// Step 5: Respond with success
 		c.JSON(http.StatusOK, gin.H{"message": "User registered", "id": id})
 	}
 }
@@ -61,7 +85,8 @@ func LoginHandler(db *sql.DB) gin.HandlerFunc {
 		// Step 2: Retrieve user from database
 		var id int
 		var hash string
-		err := db.QueryRow("SELECT id, password_hash FROM users WHERE username=$1", req.Username).Scan(&id, &hash)
+		var totpEnabled bool
+		err := db.QueryRow("SELECT id, password_hash, totp_enabled FROM users WHERE username=$1", req.Username).Scan(&id, &hash, &totpEnabled)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
 			return
@@ -74,14 +99,30 @@ func LoginHandler(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Step 4: Generate JWT using your jwt.go
-		tokenString, err := GenerateToken(id)
+		// Step 4: With 2FA on, the password only earns a challenge that
+		// POST /login/2fa exchanges for tokens
+		if totpEnabled {
+			challenge, err := newLoginChallenge(db, id)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start two-factor login"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"two_factor_required": true,
+				"challenge_token":     challenge,
+				"expires_in":          int(ChallengeTTL.Seconds()),
+			})
+			return
+		}
+
+		// Step 5: Issue an access token and a refresh token
+		pair, err := IssueTokens(db, id)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 			return
 		}
 
-		// Step 5: Respond with token
-		c.JSON(http.StatusOK, gin.H{"token": tokenString})
+		// Step 6: Respond with tokens
+		c.JSON(http.StatusOK, pair)
 	}
 }
@@ -1,43 +1,55 @@
 package auth
 
 import (
+	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret = []byte("supersecretkey")
-
 // Claims defines the payload we store in JWT
 type Claims struct {
 	UserID int `json:"user_id"`
 	jwt.RegisteredClaims
 }
 
-// GenerateToken creates a JWT for a user
-func GenerateToken(userID int) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+// GenerateToken creates a short-lived access token for a user. Its jti
+// (claims.ID) is what logout puts on the deny-list.
+func GenerateToken(userID int) (string, *Claims, error) {
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", nil, err
+	}
 
+	now := time.Now()
 	claims := &Claims{
 		UserID: userID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	// Signed with the active key; kid tells verifiers which one that was
+	token := jwt.NewWithClaims(keys.active.method, claims)
+	token.Header["kid"] = keys.active.kid
+	signed, err := token.SignedString(keys.active.sign)
+	return signed, claims, err
 }
 
 // ValidateToken parses and validates a JWT
 func ValidateToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKey,
+		jwt.WithValidMethods(keys.algs), jwt.WithExpirationRequired())
 
 	if err != nil || !token.Valid {
 		return nil, err
 	}
+	// Tokens without a jti could never be revoked
+	if claims.ID == "" {
+		return nil, errors.New("token has no jti")
+	}
 	return claims, nil
 }
@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Signing keys are configured with either a keys file, for rotation:
+//
+//	JWT_KEYS_FILE=/run/secrets/jwt-keys.yaml
+//
+//	active: 2025-10                  # kid used to sign new tokens
+//	keys:
+//	  - kid: 2025-10
+//	    alg: EdDSA                   # HS256, RS256 or EdDSA
+//	    private_key_file: /run/secrets/jwt-2025-10.pem
+//	  - kid: 2025-07                 # retiring: only verifies
+//	    alg: RS256
+//	    public_key_file: /run/secrets/jwt-2025-07.pub.pem
+//	  - kid: legacy
+//	    alg: HS256
+//	    secret_file: /run/secrets/jwt-legacy
+//
+// or a single key from the environment: JWT_ALG (default HS256), JWT_KID
+// (default "default"), and JWT_SECRET / JWT_SECRET_FILE for HS256 or
+// JWT_PRIVATE_KEY_FILE (PEM) for RS256 and EdDSA.
+//
+// Public keys of RS256/EdDSA keys are served at /.well-known/jwks.json.
+
+// minSecretLen is the shortest HS256 secret we accept, in bytes
+const minSecretLen = 32
+
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	sign   interface{} // nil for keys that only verify
+	verify interface{}
+}
+
+type keySet struct {
+	active *signingKey
+	byKID  map[string]*signingKey
+	algs   []string
+}
+
+var keys *keySet
+
+type keyConfig struct {
+	KID            string `yaml:"kid"`
+	Alg            string `yaml:"alg"`
+	Secret         string `yaml:"secret"`
+	SecretFile     string `yaml:"secret_file"`
+	PrivateKeyFile string `yaml:"private_key_file"`
+	PublicKeyFile  string `yaml:"public_key_file"`
+}
+
+type keysFile struct {
+	Active string      `yaml:"active"`
+	Keys   []keyConfig `yaml:"keys"`
+}
+
+// LoadKeys reads the signing keys from the environment. It must be called
+// before tokens are issued or validated. Without any configuration a random
+// HS256 key is generated, which logs everyone out on restart and doesn't
+// work with more than one instance.
+func LoadKeys() error {
+	var cfg keysFile
+	if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading JWT_KEYS_FILE: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("parsing JWT_KEYS_FILE: %w", err)
+		}
+	} else {
+		kc := keyConfig{
+			KID:            os.Getenv("JWT_KID"),
+			Alg:            os.Getenv("JWT_ALG"),
+			Secret:         os.Getenv("JWT_SECRET"),
+			SecretFile:     os.Getenv("JWT_SECRET_FILE"),
+			PrivateKeyFile: os.Getenv("JWT_PRIVATE_KEY_FILE"),
+		}
+		if kc.KID == "" {
+			kc.KID = "default"
+		}
+		if kc.Alg == "" {
+			kc.Alg = "HS256"
+		}
+		if kc.Alg == "HS256" && kc.Secret == "" && kc.SecretFile == "" {
+			secret, err := RandomToken(minSecretLen)
+			if err != nil {
+				return err
+			}
+			fmt.Println("⚠️ No JWT signing key configured, using a random key until restart")
+			kc.Secret = secret
+		}
+		cfg = keysFile{Active: kc.KID, Keys: []keyConfig{kc}}
+	}
+
+	set, err := buildKeySet(cfg)
+	if err != nil {
+		return err
+	}
+	keys = set
+	return nil
+}
+
+func buildKeySet(cfg keysFile) (*keySet, error) {
+	set := &keySet{byKID: map[string]*signingKey{}}
+	seenAlg := map[string]bool{}
+	for _, kc := range cfg.Keys {
+		if kc.KID == "" {
+			return nil, errors.New("every JWT key needs a kid")
+		}
+		if set.byKID[kc.KID] != nil {
+			return nil, fmt.Errorf("duplicate JWT kid %q", kc.KID)
+		}
+		k, err := loadKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %q: %w", kc.KID, err)
+		}
+		set.byKID[k.kid] = k
+		if !seenAlg[kc.Alg] {
+			seenAlg[kc.Alg] = true
+			set.algs = append(set.algs, kc.Alg)
+		}
+	}
+
+	set.active = set.byKID[cfg.Active]
+	if set.active == nil {
+		return nil, fmt.Errorf("active JWT kid %q is not configured", cfg.Active)
+	}
+	if set.active.sign == nil {
+		return nil, fmt.Errorf("active JWT key %q has no private key", cfg.Active)
+	}
+	return set, nil
+}
+
+func loadKey(kc keyConfig) (*signingKey, error) {
+	k := &signingKey{kid: kc.KID}
+	switch kc.Alg {
+	case "HS256":
+		secret := []byte(kc.Secret)
+		if kc.SecretFile != "" {
+			data, err := os.ReadFile(kc.SecretFile)
+			if err != nil {
+				return nil, err
+			}
+			secret = []byte(strings.TrimSpace(string(data)))
+		}
+		if len(secret) < minSecretLen {
+			return nil, fmt.Errorf("HS256 secret must be at least %d bytes", minSecretLen)
+		}
+		k.method = jwt.SigningMethodHS256
+		k.sign, k.verify = secret, secret
+
+	case "RS256":
+		k.method = jwt.SigningMethodRS256
+		if kc.PrivateKeyFile != "" {
+			data, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			priv, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			k.sign, k.verify = priv, &priv.PublicKey
+		} else if kc.PublicKeyFile != "" {
+			data, err := os.ReadFile(kc.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			pub, err := jwt.ParseRSAPublicKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			k.verify = pub
+		} else {
+			return nil, errors.New("private_key_file or public_key_file is required")
+		}
+
+	case "EdDSA":
+		k.method = jwt.SigningMethodEdDSA
+		if kc.PrivateKeyFile != "" {
+			data, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			priv, err := jwt.ParseEdPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			edPriv := priv.(ed25519.PrivateKey)
+			k.sign, k.verify = edPriv, edPriv.Public()
+		} else if kc.PublicKeyFile != "" {
+			data, err := os.ReadFile(kc.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			pub, err := jwt.ParseEdPublicKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			k.verify = pub
+		} else {
+			return nil, errors.New("private_key_file or public_key_file is required")
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported alg %q, use HS256, RS256 or EdDSA", kc.Alg)
+	}
+	return k, nil
+}
+
+// verificationKey picks the key named by a token's kid header, refusing
+// tokens whose alg doesn't match that key.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	k := keys.byKID[kid]
+	if k == nil {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("kid %q is not a %s key", kid, token.Method.Alg())
+	}
+	return k.verify, nil
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	KID string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSHandler - GET /.well-known/jwks.json
+// Publishes the public keys other services can verify our tokens with.
+// HS256 keys are shared secrets and never listed.
+func JWKSHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		kids := make([]string, 0, len(keys.byKID))
+		for kid := range keys.byKID {
+			kids = append(kids, kid)
+		}
+		sort.Strings(kids)
+
+		jwks := []JWK{}
+		for _, kid := range kids {
+			k := keys.byKID[kid]
+			switch pub := k.verify.(type) {
+			case *rsa.PublicKey:
+				jwks = append(jwks, JWK{
+					KID: k.kid, Kty: "RSA", Alg: k.method.Alg(), Use: "sig",
+					N: base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+					E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+				})
+			case ed25519.PublicKey:
+				jwks = append(jwks, JWK{
+					KID: k.kid, Kty: "OKP", Alg: k.method.Alg(), Use: "sig",
+					Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(pub),
+				})
+			}
+		}
+
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, gin.H{"keys": jwks})
+	}
+}
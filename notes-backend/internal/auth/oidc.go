@@ -0,0 +1,473 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// OpenID Connect login is configured with
+//
+//	OIDC_ISSUER=https://sso.example.com     # discovered via /.well-known/openid-configuration
+//	OIDC_CLIENT_ID=notes
+//	OIDC_CLIENT_SECRET=...                  # empty for public clients
+//	OIDC_REDIRECT_URL=http://localhost:8080/auth/oidc/callback
+//
+// and is off without OIDC_ISSUER.
+
+// oidcStateTTL is how long the user has to finish logging in at the provider
+const oidcStateTTL = 10 * time.Minute
+
+// oidcBindingCookie ties a login to the browser that started it, so nobody
+// can make someone else's browser finish a login or link they started
+const oidcBindingCookie = "oidc_binding"
+
+var (
+	errIdentityTaken = errors.New("identity is linked to another account")
+	errNoOIDCEmail   = errors.New("the identity provider didn't share a valid email address")
+	errEmailInUse    = errors.New("an account with this email already exists, log in and link the identity from there")
+)
+
+// OIDCProvider is a discovered OpenID Connect provider
+type OIDCProvider struct {
+	issuer   string
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// LoadOIDC discovers the provider named by OIDC_ISSUER. It returns nil when
+// SSO isn't configured.
+func LoadOIDC(ctx context.Context) (*OIDCProvider, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if clientID == "" || redirectURL == "" {
+		return nil, errors.New("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required with OIDC_ISSUER")
+	}
+
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovering %s: %w", issuer, err)
+	}
+	return &OIDCProvider{
+		issuer: issuer,
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  redirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+	}, nil
+}
+
+// Identity is an external account linked to a user
+type Identity struct {
+	ID        int       `json:"id"`
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// oidcClaims are the ID token claims we use
+type oidcClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+}
+
+// authURL starts a login, or linking to linkUserID when it isn't 0. State,
+// nonce and the PKCE verifier are kept server-side until the callback, and
+// the browser gets a cookie the callback has to present along with the state.
+func (p *OIDCProvider) authURL(c *gin.Context, db *sql.DB, linkUserID int) (string, error) {
+	state, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	binding, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	var linkUser interface{}
+	if linkUserID != 0 {
+		linkUser = linkUserID
+	}
+	_, err = db.Exec(`
+		INSERT INTO oidc_states (state_hash, binding_hash, nonce, code_verifier, link_user_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, HashToken(state), HashToken(binding), nonce, verifier, linkUser, time.Now().Add(oidcStateTTL))
+	if err != nil {
+		return "", err
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode) // sent on the redirect back from the provider
+	c.SetCookie(oidcBindingCookie, binding, int(oidcStateTTL.Seconds()), "/auth/oidc", "",
+		strings.HasPrefix(p.config.RedirectURL, "https://"), true)
+
+	return p.config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// oidcRedirect sends the browser back to the frontend, with the result in
+// the fragment so tokens never reach a server log
+func oidcRedirect(c *gin.Context, params url.Values) {
+	c.Redirect(http.StatusFound, appURL()+"/login/oidc#"+params.Encode())
+}
+
+func oidcError(c *gin.Context, message string) {
+	oidcRedirect(c, url.Values{"error": {message}})
+}
+
+// OIDCLoginHandler - GET /auth/oidc/login
+func OIDCLoginHandler(db *sql.DB, p *OIDCProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p == nil {
+			oidcError(c, "Single sign-on is not configured")
+			return
+		}
+		u, err := p.authURL(c, db, 0)
+		if err != nil {
+			oidcError(c, "Failed to start single sign-on")
+			return
+		}
+		c.Redirect(http.StatusFound, u)
+	}
+}
+
+// LinkIdentityHandler - POST /auth/identities
+// Returns the provider URL to send the browser to; the callback then links
+// that identity to the current user. The request has to be made with
+// credentials so the browser keeps the binding cookie.
+func LinkIdentityHandler(db *sql.DB, p *OIDCProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+			return
+		}
+		u, err := p.authURL(c, db, c.GetInt("userID"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start linking"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"url": u})
+	}
+}
+
+// OIDCCallbackHandler - GET /auth/oidc/callback
+// Finishes the code flow and either links the identity or logs the user in,
+// creating an account on first login.
+func OIDCCallbackHandler(db *sql.DB, p *OIDCProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p == nil {
+			oidcError(c, "Single sign-on is not configured")
+			return
+		}
+		if e := c.Query("error"); e != "" {
+			oidcError(c, "The identity provider refused the login: "+e)
+			return
+		}
+
+		// The state is single-use and only works in the browser it was made for
+		binding, err := c.Cookie(oidcBindingCookie)
+		if err != nil || binding == "" {
+			oidcError(c, "Login expired or was started in another browser, please try again")
+			return
+		}
+		c.SetCookie(oidcBindingCookie, "", -1, "/auth/oidc", "", strings.HasPrefix(p.config.RedirectURL, "https://"), true)
+
+		var nonce, verifier string
+		var linkUserID sql.NullInt64
+		err = db.QueryRow(`
+			DELETE FROM oidc_states WHERE state_hash=$1 AND binding_hash=$2 AND expires_at > NOW()
+			RETURNING nonce, code_verifier, link_user_id
+		`, HashToken(c.Query("state")), HashToken(binding)).Scan(&nonce, &verifier, &linkUserID)
+		if err == sql.ErrNoRows {
+			oidcError(c, "Login expired or was started in another browser, please try again")
+			return
+		} else if err != nil {
+			oidcError(c, "Failed to finish single sign-on")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+		token, err := p.config.Exchange(ctx, c.Query("code"), oauth2.VerifierOption(verifier))
+		if err != nil {
+			fmt.Printf("❌ OIDC code exchange failed: %v\n", err)
+			oidcError(c, "Failed to finish single sign-on")
+			return
+		}
+		rawIDToken, ok := token.Extra("id_token").(string)
+		if !ok {
+			oidcError(c, "The identity provider returned no ID token")
+			return
+		}
+		idToken, err := p.verifier.Verify(ctx, rawIDToken)
+		if err != nil || idToken.Nonce != nonce {
+			oidcError(c, "Invalid ID token")
+			return
+		}
+		var claims oidcClaims
+		if err := idToken.Claims(&claims); err != nil {
+			oidcError(c, "Invalid ID token")
+			return
+		}
+
+		if linkUserID.Valid {
+			err := linkIdentity(db, int(linkUserID.Int64), p.issuer, claims)
+			if err == errIdentityTaken {
+				oidcError(c, "This identity is already linked to another account")
+				return
+			} else if err != nil {
+				oidcError(c, "Failed to link identity")
+				return
+			}
+			oidcRedirect(c, url.Values{"linked": {"1"}})
+			return
+		}
+
+		userID, err := findOrCreateOIDCUser(db, p.issuer, claims)
+		if err == errNoOIDCEmail || err == errEmailInUse {
+			oidcError(c, "Failed to log in: "+err.Error())
+			return
+		} else if err != nil {
+			fmt.Printf("❌ OIDC login failed: %v\n", err)
+			oidcError(c, "Failed to log in")
+			return
+		}
+
+		// Accounts with 2FA still need their code
+		var totpEnabled bool
+		if err := db.QueryRow(`SELECT totp_enabled FROM users WHERE id=$1`, userID).Scan(&totpEnabled); err != nil {
+			oidcError(c, "Failed to log in")
+			return
+		}
+		if totpEnabled {
+			challenge, err := newLoginChallenge(db, userID)
+			if err != nil {
+				oidcError(c, "Failed to start two-factor login")
+				return
+			}
+			oidcRedirect(c, url.Values{"challenge_token": {challenge}})
+			return
+		}
+
+		pair, err := IssueTokens(db, userID)
+		if err != nil {
+			oidcError(c, "Failed to generate token")
+			return
+		}
+		oidcRedirect(c, url.Values{
+			"token":         {pair.Token},
+			"refresh_token": {pair.RefreshToken},
+			"expires_in":    {fmt.Sprint(pair.ExpiresIn)},
+		})
+	}
+}
+
+func linkIdentity(db *sql.DB, userID int, issuer string, claims oidcClaims) error {
+	var owner int
+	err := db.QueryRow(`
+		INSERT INTO user_identities (user_id, issuer, subject, email)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (issuer, subject) DO UPDATE SET email=EXCLUDED.email
+		WHERE user_identities.user_id=EXCLUDED.user_id
+		RETURNING user_id
+	`, userID, issuer, claims.Subject, claims.Email).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return errIdentityTaken
+	}
+	return err
+}
+
+// findOrCreateOIDCUser resolves an identity to a user. A new identity is
+// attached to the local account with the same address only when both sides
+// have verified it; otherwise a new account is created.
+func findOrCreateOIDCUser(db *sql.DB, issuer string, claims oidcClaims) (int, error) {
+	var userID int
+	err := db.QueryRow(`SELECT user_id FROM user_identities WHERE issuer=$1 AND subject=$2`, issuer, claims.Subject).Scan(&userID)
+	if err == nil {
+		return userID, nil
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	email, err := NormalizeEmail(claims.Email)
+	if err != nil {
+		return 0, errNoOIDCEmail
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var verifiedAt sql.NullTime
+	err = tx.QueryRow(`SELECT id, email_verified_at FROM users WHERE lower(email)=$1`, email).Scan(&userID, &verifiedAt)
+	switch {
+	case err == nil && claims.EmailVerified && verifiedAt.Valid:
+		// Same verified address: this is the same person
+	case err == nil:
+		return 0, errEmailInUse
+	case err == sql.ErrNoRows:
+		username, err := availableUsername(tx, claims)
+		if err != nil {
+			return 0, err
+		}
+		// No password: the account can only log in through SSO until one is
+		// set with a password reset
+		var verified interface{}
+		if claims.EmailVerified {
+			verified = time.Now()
+		}
+		err = tx.QueryRow(`
+			INSERT INTO users (username, email, password_hash, email_verified_at)
+			VALUES ($1, $2, '', $3) RETURNING id
+		`, username, email, verified).Scan(&userID)
+		if err != nil {
+			return 0, err
+		}
+	default:
+		return 0, err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO user_identities (user_id, issuer, subject, email) VALUES ($1, $2, $3, $4)
+	`, userID, issuer, claims.Subject, claims.Email); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+var usernameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// availableUsername derives a free username from the provider's claims
+func availableUsername(tx *sql.Tx, claims oidcClaims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+	base = usernameChars.ReplaceAllString(base, "")
+	if base == "" {
+		base = "user"
+	}
+
+	for i := 1; ; i++ {
+		candidate := base
+		if i > 1 {
+			candidate = fmt.Sprintf("%s%d", base, i)
+		}
+		var taken bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE username=$1)`, candidate).Scan(&taken); err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+	}
+}
+
+// ListIdentitiesHandler - GET /auth/identities
+func ListIdentitiesHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		rows, err := db.Query(`
+			SELECT id, issuer, subject, email, created_at
+			FROM user_identities WHERE user_id=$1 ORDER BY created_at
+		`, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch identities"})
+			return
+		}
+		defer rows.Close()
+
+		identities := []Identity{}
+		for rows.Next() {
+			var i Identity
+			if err := rows.Scan(&i.ID, &i.Issuer, &i.Subject, &i.Email, &i.CreatedAt); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan identity"})
+				return
+			}
+			identities = append(identities, i)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"identities": identities})
+	}
+}
+
+// UnlinkIdentityHandler - DELETE /auth/identities/:id
+// Refuses to remove the last way to log in.
+func UnlinkIdentityHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+		identityID := c.Param("id")
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		var hasPassword bool
+		err = tx.QueryRow(`SELECT password_hash <> '' FROM users WHERE id=$1 FOR UPDATE`, userID).Scan(&hasPassword)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+			return
+		}
+
+		res, err := tx.Exec(`DELETE FROM user_identities WHERE id=$1 AND user_id=$2`, identityID, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink identity"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Identity not found"})
+			return
+		}
+
+		if !hasPassword {
+			var remaining int
+			if err := tx.QueryRow(`SELECT COUNT(*) FROM user_identities WHERE user_id=$1`, userID).Scan(&remaining); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink identity"})
+				return
+			}
+			if remaining == 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "Set a password before unlinking your only identity"})
+				return
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink identity"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Identity unlinked"})
+	}
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Personal access tokens are opaque and start with this prefix, which is how
+// the middleware tells them apart from JWTs.
+const PersonalTokenPrefix = "pat_"
+
+// Scopes a personal access token can be limited to
+const (
+	ScopeNotesRead   = "notes:read"
+	ScopeNotesWrite  = "notes:write"
+	ScopeImagesWrite = "images:write"
+)
+
+var validScopes = map[string]bool{ScopeNotesRead: true, ScopeNotesWrite: true, ScopeImagesWrite: true}
+
+// lastUsedResolution is how stale last_used_at may get, to save a write on
+// every request
+const lastUsedResolution = time.Minute
+
+var errInvalidPersonalToken = errors.New("invalid personal access token")
+
+type PersonalToken struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // first characters, to recognise it
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// ValidatePersonalToken returns the user and scopes of a live token
+func ValidatePersonalToken(db *sql.DB, token string) (int, []string, error) {
+	var id, userID int
+	var scopes []string
+	var lastUsed sql.NullTime
+	now := time.Now()
+	err := db.QueryRow(`
+		SELECT id, user_id, scopes, last_used_at
+		FROM personal_access_tokens
+		WHERE token_hash=$1 AND (expires_at IS NULL OR expires_at > $2)
+	`, HashToken(token), now).Scan(&id, &userID, pq.Array(&scopes), &lastUsed)
+	if err == sql.ErrNoRows {
+		return 0, nil, errInvalidPersonalToken
+	} else if err != nil {
+		return 0, nil, err
+	}
+
+	if !lastUsed.Valid || now.Sub(lastUsed.Time) > lastUsedResolution {
+		if _, err := db.Exec(`UPDATE personal_access_tokens SET last_used_at=$1 WHERE id=$2`, now, id); err != nil {
+			return 0, nil, err
+		}
+	}
+	return userID, scopes, nil
+}
+
+// CreatePersonalTokenHandler - POST /tokens
+// The token is only ever shown in this response.
+func CreatePersonalTokenHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		var req struct {
+			Name      string     `json:"name" binding:"required"`
+			Scopes    []string   `json:"scopes" binding:"required"`
+			ExpiresAt *time.Time `json:"expires_at"` // optional, never expires without it
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Token name is required"})
+			return
+		}
+		if len(req.Scopes) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "At least one scope is required"})
+			return
+		}
+		for _, s := range req.Scopes {
+			if !validScopes[s] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope " + s + ", use notes:read, notes:write or images:write"})
+				return
+			}
+		}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+			return
+		}
+
+		secret, err := RandomToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+			return
+		}
+		token := PersonalTokenPrefix + secret
+
+		t := PersonalToken{Name: name, Prefix: token[:len(PersonalTokenPrefix)+6], Scopes: req.Scopes, ExpiresAt: req.ExpiresAt}
+		err = db.QueryRow(`
+			INSERT INTO personal_access_tokens (user_id, name, token_hash, prefix, scopes, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id, created_at
+		`, userID, t.Name, HashToken(token), t.Prefix, pq.Array(t.Scopes), t.ExpiresAt).Scan(&t.ID, &t.CreatedAt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"token": token, "personal_token": t})
+	}
+}
+
+// ListPersonalTokensHandler - GET /tokens
+func ListPersonalTokensHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		rows, err := db.Query(`
+			SELECT id, name, prefix, scopes, expires_at, last_used_at, created_at
+			FROM personal_access_tokens
+			WHERE user_id=$1
+			ORDER BY created_at DESC
+		`, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tokens"})
+			return
+		}
+		defer rows.Close()
+
+		tokens := []PersonalToken{}
+		for rows.Next() {
+			var t PersonalToken
+			if err := rows.Scan(&t.ID, &t.Name, &t.Prefix, pq.Array(&t.Scopes), &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan token"})
+				return
+			}
+			tokens = append(tokens, t)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+	}
+}
+
+// DeletePersonalTokenHandler - DELETE /tokens/:id
+func DeletePersonalTokenHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+		tokenID := c.Param("id")
+
+		res, err := db.Exec(`DELETE FROM personal_access_tokens WHERE id=$1 AND user_id=$2`, tokenID, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete token"})
+			return
+		}
+		rowsAffected, _ := res.RowsAffected()
+		if rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Token deleted successfully"})
+	}
+}
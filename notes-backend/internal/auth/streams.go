@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StreamTicketTTL is how long a stream ticket can wait before it is used
+const StreamTicketTTL = 30 * time.Second
+
+var errInvalidStreamTicket = errors.New("invalid stream ticket")
+
+// StreamSession is the access token a stream was opened with
+type StreamSession struct {
+	UserID    int
+	JTI       string
+	ExpiresAt time.Time
+}
+
+// CreateStreamTicketHandler - POST /stream-tickets
+// Browsers can't set headers on EventSource or WebSocket requests, so they
+// trade their access token for a ticket to put in the URL instead. A ticket
+// works once, for StreamTicketTTL, and the stream it opens ends with the
+// access token it was made from.
+func CreateStreamTicketHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ticket, err := RandomToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create ticket"})
+			return
+		}
+		_, err = db.Exec(`
+			INSERT INTO stream_tickets (ticket_hash, user_id, access_jti, access_expires_at, expires_at)
+			VALUES ($1, $2, $3, $4, $5)
+		`, HashToken(ticket), c.GetInt("userID"), c.GetString("jti"), c.GetTime("tokenExpiresAt"), time.Now().Add(StreamTicketTTL))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create ticket"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"ticket":     ticket,
+			"expires_in": int(StreamTicketTTL.Seconds()),
+		})
+	}
+}
+
+// RedeemStreamTicket uses up a ticket and returns the session it was made
+// for, as long as that session's access token is still valid.
+func RedeemStreamTicket(db *sql.DB, ticket string) (StreamSession, error) {
+	var s StreamSession
+	now := time.Now()
+	err := db.QueryRow(`
+		DELETE FROM stream_tickets
+		WHERE ticket_hash=$1 AND expires_at > $2
+		RETURNING user_id, access_jti, access_expires_at
+	`, HashToken(ticket), now).Scan(&s.UserID, &s.JTI, &s.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return StreamSession{}, errInvalidStreamTicket
+	} else if err != nil {
+		return StreamSession{}, err
+	}
+
+	ok, err := SessionValid(db, s.JTI, s.ExpiresAt)
+	if err != nil {
+		return StreamSession{}, err
+	}
+	if !ok {
+		return StreamSession{}, errInvalidStreamTicket
+	}
+	return s, nil
+}
+
+// SessionValid reports whether an access token is neither expired nor
+// revoked. Streams check it as they go, so logging out ends them too.
+func SessionValid(db *sql.DB, jti string, expiresAt time.Time) (bool, error) {
+	if !time.Now().Before(expiresAt) {
+		return false, nil
+	}
+	revoked, err := IsRevoked(db, jti)
+	return !revoked, err
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+var streamTables = append([]string{
+	`CREATE TEMP TABLE stream_tickets (
+		ticket_hash TEXT PRIMARY KEY,
+		user_id INT,
+		access_jti TEXT NOT NULL,
+		access_expires_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	)`,
+}, tokenTables...)
+
+func TestStreamTicketsWorkOnce(t *testing.T) {
+	db := testDB(t, streamTables...)
+	exp := time.Now().Add(AccessTokenTTL)
+	add := func(ticket, jti string, accessExpiresAt, expiresAt time.Time) {
+		t.Helper()
+		_, err := db.Exec(`INSERT INTO stream_tickets VALUES ($1, 7, $2, $3, $4)`,
+			HashToken(ticket), jti, accessExpiresAt, expiresAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("good", "jti-1", exp, time.Now().Add(StreamTicketTTL))
+	add("stale", "jti-2", exp, time.Now().Add(-time.Second))
+	add("old token", "jti-3", time.Now().Add(-time.Second), time.Now().Add(StreamTicketTTL))
+	add("logged out", "jti-4", exp, time.Now().Add(StreamTicketTTL))
+	if err := denyAccessToken(db, "jti-4", exp); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := RedeemStreamTicket(db, "good")
+	if err != nil {
+		t.Fatalf("RedeemStreamTicket: %v", err)
+	}
+	if s.UserID != 7 || s.JTI != "jti-1" {
+		t.Errorf("session = %+v", s)
+	}
+	for _, ticket := range []string{"good", "stale", "old token", "logged out", "unknown"} {
+		if _, err := RedeemStreamTicket(db, ticket); err != errInvalidStreamTicket {
+			t.Errorf("%s: err = %v, want errInvalidStreamTicket", ticket, err)
+		}
+	}
+}
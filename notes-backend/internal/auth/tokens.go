@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
+// TokenPair is what a successful login or refresh returns
+type TokenPair struct {
+	Token        string `json:"token"` // access token, sent as a Bearer token
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // seconds until the access token expires
+}
+
+// RandomToken returns a URL-safe random string with n bytes of entropy
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken is how opaque tokens are stored; they are random enough that a
+// plain SHA-256 is sufficient.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueTokens starts a new session for a user: an access token plus a
+// refresh token that starts a new rotation family.
+func IssueTokens(db *sql.DB, userID int) (TokenPair, error) {
+	family, err := RandomToken(16)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return issueTokens(db, userID, family)
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func issueTokens(q execer, userID int, family string) (TokenPair, error) {
+	access, claims, err := GenerateToken(userID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	refresh, err := RandomToken(32)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	_, err = q.Exec(`
+		INSERT INTO refresh_tokens (user_id, token_hash, family, access_jti, access_expires_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, userID, HashToken(refresh), family, claims.ID, claims.ExpiresAt.Time, time.Now().Add(RefreshTokenTTL))
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{Token: access, RefreshToken: refresh, ExpiresIn: int(AccessTokenTTL.Seconds())}, nil
+}
+
+// rotate swaps a refresh token for a new pair. Presenting a token that was
+// already used means it was copied, so its whole family is revoked.
+func rotate(db *sql.DB, refresh string) (TokenPair, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	defer tx.Rollback()
+
+	var id, userID int
+	var family string
+	var expiresAt time.Time
+	var revokedAt sql.NullTime
+	err = tx.QueryRow(`
+		SELECT id, user_id, family, expires_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash=$1
+		FOR UPDATE
+	`, HashToken(refresh)).Scan(&id, &userID, &family, &expiresAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return TokenPair{}, errInvalidRefreshToken
+	} else if err != nil {
+		return TokenPair{}, err
+	}
+
+	if revokedAt.Valid {
+		if err := revokeRefreshTokens(tx, `family=$2`, family); err != nil {
+			return TokenPair{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return TokenPair{}, err
+		}
+		return TokenPair{}, errInvalidRefreshToken
+	}
+	if time.Now().After(expiresAt) {
+		return TokenPair{}, errInvalidRefreshToken
+	}
+
+	if _, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at=$1 WHERE id=$2`, time.Now(), id); err != nil {
+		return TokenPair{}, err
+	}
+	pair, err := issueTokens(tx, userID, family)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return pair, tx.Commit()
+}
+
+// revokeRefreshTokens revokes the live refresh tokens matching a condition
+// and denies every unexpired access token issued with any of them. Rotated
+// refresh tokens are already revoked, but the access tokens issued with
+// them stay valid until they expire, so they are denied too.
+func revokeRefreshTokens(tx *sql.Tx, where string, args ...interface{}) error {
+	now := time.Now()
+	if _, err := tx.Exec(`
+		INSERT INTO revoked_tokens (jti, expires_at)
+		SELECT access_jti, access_expires_at FROM refresh_tokens
+		WHERE access_jti IS NOT NULL AND access_expires_at > $1 AND `+where+`
+		ON CONFLICT (jti) DO NOTHING
+	`, append([]interface{}{now}, args...)...); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		UPDATE refresh_tokens SET revoked_at=$1
+		WHERE revoked_at IS NULL AND `+where, append([]interface{}{now}, args...)...)
+	return err
+}
+
+// RevokeAllSessions ends every session of a user, as logging out
+// everywhere does
+func RevokeAllSessions(tx *sql.Tx, userID int) error {
+	return revokeRefreshTokens(tx, `user_id=$2`, userID)
+}
+
+// denyAccessToken puts an access token on the deny-list until it expires
+func denyAccessToken(q execer, jti string, expiresAt time.Time) error {
+	_, err := q.Exec(`
+		INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING
+	`, jti, expiresAt)
+	return err
+}
+
+// IsRevoked reports whether an access token was revoked before expiring
+func IsRevoked(db *sql.DB, jti string) (bool, error) {
+	var revoked bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti=$1)`, jti).Scan(&revoked)
+	return revoked, err
+}
+
+// RefreshTokenHandler - POST /token/refresh
+func RefreshTokenHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			RefreshToken string `json:"refresh_token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		pair, err := rotate(db, req.RefreshToken)
+		if err == errInvalidRefreshToken {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, pair)
+	}
+}
+
+// LogoutHandler - POST /logout
+// Revokes the access token used for the call and, if given, its refresh token.
+func LogoutHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		// The body is optional
+		c.ShouldBindJSON(&req)
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+		defer tx.Rollback()
+
+		if err := denyAccessToken(tx, c.GetString("jti"), c.GetTime("tokenExpiresAt")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+		if req.RefreshToken != "" {
+			err := revokeRefreshTokens(tx, `token_hash=$2 AND user_id=$3`, HashToken(req.RefreshToken), userID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+				return
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+	}
+}
+
+// LogoutAllHandler - POST /logout-all
+// Ends every session of the user.
+func LogoutAllHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+		defer tx.Rollback()
+
+		if err := revokeRefreshTokens(tx, `user_id=$2`, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+		if err := denyAccessToken(tx, c.GetString("jti"), c.GetTime("tokenExpiresAt")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out everywhere"})
+	}
+}
+
+// StartTokenSweeper deletes expired refresh tokens, deny-list entries and
+// other short-lived tokens every interval. Revoked refresh tokens are kept
+// until they expire so reuse can still be detected.
+func StartTokenSweeper(db *sql.DB, interval time.Duration) {
+	go func() {
+		for {
+			now := time.Now()
+			if _, err := db.Exec(`DELETE FROM revoked_tokens WHERE expires_at < $1`, now); err != nil {
+				fmt.Printf("❌ Failed to sweep revoked tokens: %v\n", err)
+			}
+			if _, err := db.Exec(`DELETE FROM refresh_tokens WHERE expires_at < $1`, now); err != nil {
+				fmt.Printf("❌ Failed to sweep refresh tokens: %v\n", err)
+			}
+			if _, err := db.Exec(`DELETE FROM login_challenges WHERE expires_at < $1`, now); err != nil {
+				fmt.Printf("❌ Failed to sweep login challenges: %v\n", err)
+			}
+			if _, err := db.Exec(`DELETE FROM email_tokens WHERE expires_at < $1`, now); err != nil {
+				fmt.Printf("❌ Failed to sweep email tokens: %v\n", err)
+			}
+			if _, err := db.Exec(`DELETE FROM oidc_states WHERE expires_at < $1`, now); err != nil {
+				fmt.Printf("❌ Failed to sweep OIDC states: %v\n", err)
+			}
+			if _, err := db.Exec(`DELETE FROM stream_tickets WHERE expires_at < $1`, now); err != nil {
+				fmt.Printf("❌ Failed to sweep stream tickets: %v\n", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
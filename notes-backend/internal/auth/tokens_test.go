@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	_ "github.com/lib/pq"
+)
+
+// testDB connects to the Postgres database in TEST_DATABASE_URL, and skips
+// the test without one. The given statements create temporary tables, which
+// shadow the real ones on the test's single connection and go away with it.
+func testDB(t *testing.T, tables ...string) *sql.DB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	for _, table := range tables {
+		if _, err := db.Exec(table); err != nil {
+			t.Fatalf("creating test table: %v", err)
+		}
+	}
+	if err := LoadKeys(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+var tokenTables = []string{
+	`CREATE TEMP TABLE refresh_tokens (
+		id SERIAL PRIMARY KEY,
+		user_id INT,
+		token_hash TEXT NOT NULL UNIQUE,
+		family TEXT NOT NULL,
+		access_jti TEXT,
+		access_expires_at TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TEMP TABLE revoked_tokens (
+		jti TEXT PRIMARY KEY,
+		expires_at TIMESTAMP NOT NULL
+	)`,
+}
+
+func requireRevoked(t *testing.T, db *sql.DB, name, token string) {
+	t.Helper()
+	claims, err := ValidateToken(token)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	revoked, err := IsRevoked(db, claims.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked {
+		t.Errorf("%s access token is still valid", name)
+	}
+}
+
+// The access token issued before a rotation outlives its refresh token, and
+// must still be denied when the sessions are revoked
+func TestRevokeAfterRotation(t *testing.T) {
+	db := testDB(t, tokenTables...)
+
+	first, err := IssueTokens(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := rotate(db, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	other, err := IssueTokens(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RevokeAllSessions(tx, 1); err != nil {
+		t.Fatalf("RevokeAllSessions: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	requireRevoked(t, db, "rotated", first.Token)
+	requireRevoked(t, db, "current", second.Token)
+	if _, err := rotate(db, second.RefreshToken); err != errInvalidRefreshToken {
+		t.Errorf("refreshing after revoking: err = %v", err)
+	}
+
+	claims, err := ValidateToken(other.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked, _ := IsRevoked(db, claims.ID); revoked {
+		t.Error("another user's session was revoked")
+	}
+}
+
+// Reusing a rotated refresh token revokes its family, including the access
+// token issued before the rotation
+func TestReuseRevokesFamily(t *testing.T) {
+	db := testDB(t, tokenTables...)
+
+	first, err := IssueTokens(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := rotate(db, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if _, err := rotate(db, first.RefreshToken); err != errInvalidRefreshToken {
+		t.Fatalf("reusing a refresh token: err = %v", err)
+	}
+
+	requireRevoked(t, db, "rotated", first.Token)
+	requireRevoked(t, db, "current", second.Token)
+}
@@ -0,0 +1,536 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TOTP as in RFC 6238 with the parameters every authenticator app supports:
+// HMAC-SHA1, 6 digits, 30 second steps.
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// totpSkew is how many steps either side of now are accepted, for clock drift
+	totpSkew = 1
+)
+
+const (
+	ChallengeTTL         = 5 * time.Minute
+	maxChallengeAttempts = 5
+	recoveryCodeCount    = 10
+	totpSecretBytes      = 20
+	defaultTOTPIssuer    = "Notes"
+)
+
+// After maxTOTPFailures wrong codes in a row, whatever challenge they came
+// with, codes are refused for totpLockout, doubling with each further
+// failure up to maxTOTPLockout
+const (
+	maxTOTPFailures = 5
+	totpLockout     = time.Minute
+	maxTOTPLockout  = time.Hour
+)
+
+var base32NoPad = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpCode computes the code for one time step
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, bin%1000000)
+}
+
+// matchTOTP returns the time step a code is valid for, or -1
+func matchTOTP(secret string, code string, now time.Time) int64 {
+	key, err := base32NoPad.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return -1
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step
+		}
+	}
+	return -1
+}
+
+// verifyTOTP checks a code against a user's confirmed secret. Each time step
+// is accepted only once, so an observed code can't be replayed.
+func verifyTOTP(q queryRower, userID int, code string) (bool, error) {
+	var secret sql.NullString
+	var lastStep int64
+	err := q.QueryRow(`SELECT totp_secret, totp_last_step FROM users WHERE id=$1 AND totp_enabled`, userID).
+		Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	step := matchTOTP(secret.String, normalizeCode(code), time.Now())
+	if step < 0 || step <= lastStep {
+		return false, nil
+	}
+	var id int
+	err = q.QueryRow(`UPDATE users SET totp_last_step=$1 WHERE id=$2 AND totp_last_step < $1 RETURNING id`, step, userID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil // the same code was just used by a concurrent request
+	}
+	return err == nil, err
+}
+
+// useRecoveryCode consumes one of a user's recovery codes
+func useRecoveryCode(q queryRower, userID int, code string) (bool, error) {
+	var id int
+	err := q.QueryRow(`
+		UPDATE recovery_codes SET used_at=NOW()
+		WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL
+		RETURNING id
+	`, userID, HashToken(normalizeCode(code))).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+var errTOTPLocked = errors.New("too many invalid codes")
+
+// verifySecondFactor accepts either a TOTP code or a recovery code. Failures
+// are counted per user, and once there are too many it returns
+// errTOTPLocked without checking the code. The count is only reset by a
+// valid code, so callers must commit q after a failure too.
+func verifySecondFactor(q queryRower, userID int, code, recoveryCode string) (bool, error) {
+	// Locking the row makes concurrent guesses wait for each other's count
+	var lockedUntil sql.NullTime
+	err := q.QueryRow(`SELECT totp_locked_until FROM users WHERE id=$1 FOR UPDATE`, userID).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if lockedUntil.Valid && now.Before(lockedUntil.Time) {
+		return false, errTOTPLocked
+	}
+
+	var ok bool
+	if recoveryCode != "" {
+		ok, err = useRecoveryCode(q, userID, recoveryCode)
+	} else {
+		ok, err = verifyTOTP(q, userID, code)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if ok {
+		_, err = q.Exec(`UPDATE users SET totp_failures=0, totp_locked_until=NULL WHERE id=$1`, userID)
+		return err == nil, err
+	}
+	var failures int
+	if err := q.QueryRow(`UPDATE users SET totp_failures=totp_failures+1 WHERE id=$1 RETURNING totp_failures`, userID).Scan(&failures); err != nil {
+		return false, err
+	}
+	if lockout := totpLockoutAfter(failures); lockout > 0 {
+		if _, err := q.Exec(`UPDATE users SET totp_locked_until=$1 WHERE id=$2`, now.Add(lockout), userID); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// totpLockoutAfter is how long codes are refused after failures wrong ones
+// in a row
+func totpLockoutAfter(failures int) time.Duration {
+	if failures < maxTOTPFailures {
+		return 0
+	}
+	lockout := totpLockout
+	for i := maxTOTPFailures; i < failures && lockout < maxTOTPLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > maxTOTPLockout {
+		lockout = maxTOTPLockout
+	}
+	return lockout
+}
+
+// normalizeCode drops the spaces and dashes people type into codes
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+type queryRower interface {
+	execer
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// newRecoveryCodes replaces a user's recovery codes and returns the new
+// ones, formatted xxxxx-xxxxx. Only their hashes are kept.
+func newRecoveryCodes(q execer, userID int) ([]string, error) {
+	if _, err := q.Exec(`DELETE FROM recovery_codes WHERE user_id=$1`, userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(base32NoPad.EncodeToString(b))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		if _, err := q.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, HashToken(raw)); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// newLoginChallenge is handed out instead of tokens when the password was
+// right but a second factor is still needed.
+func newLoginChallenge(db *sql.DB, userID int) (string, error) {
+	challenge, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	_, err = db.Exec(`INSERT INTO login_challenges (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`,
+		HashToken(challenge), userID, time.Now().Add(ChallengeTTL))
+	return challenge, err
+}
+
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return defaultTOTPIssuer
+}
+
+// TwoFactorLoginHandler - POST /login/2fa
+// Exchanges the challenge token from /login and a TOTP or recovery code for
+// the real tokens.
+func TwoFactorLoginHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			ChallengeToken string `json:"challenge_token" binding:"required"`
+			Code           string `json:"code"`
+			RecoveryCode   string `json:"recovery_code"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || (req.Code == "") == (req.RecoveryCode == "") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "challenge_token and either code or recovery_code are required"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		// Every attempt counts, so a challenge can't be used to brute-force codes
+		var userID int
+		err = tx.QueryRow(`
+			UPDATE login_challenges SET attempts=attempts+1
+			WHERE token_hash=$1 AND expires_at > NOW() AND attempts < $2
+			RETURNING user_id
+		`, HashToken(req.ChallengeToken), maxChallengeAttempts).Scan(&userID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge, log in again"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check challenge"})
+			return
+		}
+
+		ok, err := verifySecondFactor(tx, userID, req.Code, req.RecoveryCode)
+		if err == errTOTPLocked {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many invalid codes, try again later"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+			return
+		}
+		if !ok {
+			// Keep the attempt and failure counts
+			if err := tx.Commit(); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
+
+		if _, err := tx.Exec(`DELETE FROM login_challenges WHERE token_hash=$1`, HashToken(req.ChallengeToken)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+			return
+		}
+		family, err := RandomToken(16)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		pair, err := issueTokens(tx, userID, family)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, pair)
+	}
+}
+
+// SetupTOTPHandler - POST /2fa/setup
+// Starts enrolment with a fresh secret. 2FA stays off until the first code
+// is confirmed.
+func SetupTOTPHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		b := make([]byte, totpSecretBytes)
+		if _, err := rand.Read(b); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+			return
+		}
+		secret := base32NoPad.EncodeToString(b)
+
+		var username string
+		err := db.QueryRow(`
+			UPDATE users SET totp_secret=$1, totp_last_step=0
+			WHERE id=$2 AND NOT totp_enabled
+			RETURNING username
+		`, secret, userID).Scan(&username)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start setup"})
+			return
+		}
+
+		issuer := totpIssuer()
+		params := url.Values{}
+		params.Set("secret", secret)
+		params.Set("issuer", issuer)
+		params.Set("algorithm", "SHA1")
+		params.Set("digits", fmt.Sprint(totpDigits))
+		params.Set("period", fmt.Sprint(totpPeriod))
+		uri := url.URL{
+			Scheme:   "otpauth",
+			Host:     "totp",
+			Path:     "/" + issuer + ":" + username,
+			RawQuery: params.Encode(),
+		}
+
+		c.JSON(http.StatusOK, gin.H{"secret": secret, "otpauth_uri": uri.String()})
+	}
+}
+
+// ConfirmTOTPHandler - POST /2fa/confirm
+// Enables 2FA once the authenticator produces a valid code and returns the
+// recovery codes, which are only ever shown here.
+func ConfirmTOTPHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		var req struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		var secret sql.NullString
+		var enabled bool
+		err = tx.QueryRow(`SELECT totp_secret, totp_enabled FROM users WHERE id=$1 FOR UPDATE`, userID).Scan(&secret, &enabled)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+			return
+		}
+		if enabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+			return
+		}
+		if !secret.Valid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Start setup with POST /2fa/setup first"})
+			return
+		}
+
+		step := matchTOTP(secret.String, normalizeCode(req.Code), time.Now())
+		if step < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+			return
+		}
+
+		if _, err := tx.Exec(`UPDATE users SET totp_enabled=TRUE, totp_last_step=$1 WHERE id=$2`, step, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+			return
+		}
+		codes, err := newRecoveryCodes(tx, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create recovery codes"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication enabled", "recovery_codes": codes})
+	}
+}
+
+// RegenerateRecoveryCodesHandler - POST /2fa/recovery-codes
+// Replaces all recovery codes; needs a current TOTP code.
+func RegenerateRecoveryCodesHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		var req struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		ok, err := verifySecondFactor(tx, userID, req.Code, "")
+		if err == errTOTPLocked {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many invalid codes, try again later"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+			return
+		}
+		if !ok {
+			// Keep the failure count
+			if err := tx.Commit(); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
+		codes, err := newRecoveryCodes(tx, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create recovery codes"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create recovery codes"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+	}
+}
+
+// DisableTOTPHandler - POST /2fa/disable
+// Needs the password and a TOTP or recovery code, so a stolen session alone
+// can't turn 2FA off.
+func DisableTOTPHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		var req struct {
+			Password     string `json:"password" binding:"required"`
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || (req.Code == "") == (req.RecoveryCode == "") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "password and either code or recovery_code are required"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		var hash string
+		if err := tx.QueryRow(`SELECT password_hash FROM users WHERE id=$1`, userID).Scan(&hash); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+			return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+			return
+		}
+
+		ok, err := verifySecondFactor(tx, userID, req.Code, req.RecoveryCode)
+		if err == errTOTPLocked {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many invalid codes, try again later"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+			return
+		}
+		if !ok {
+			// Keep the failure count
+			if err := tx.Commit(); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
+
+		if _, err := tx.Exec(`UPDATE users SET totp_enabled=FALSE, totp_secret=NULL, totp_last_step=0 WHERE id=$1`, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+			return
+		}
+		if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id=$1`, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+	}
+}
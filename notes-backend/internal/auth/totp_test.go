@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors of RFC 6238 appendix B. They are 8 digits long;
+// 6-digit codes are their last six digits.
+var rfc6238Secret = []byte("12345678901234567890")
+
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		want := v.code[len(v.code)-totpDigits:]
+		if got := totpCode(rfc6238Secret, v.unix/totpPeriod); got != want {
+			t.Errorf("T=%d: code %s, want %s", v.unix, got, want)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	secret := base32NoPad.EncodeToString(rfc6238Secret)
+	if secret != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" {
+		t.Fatalf("secret encodes as %s", secret)
+	}
+
+	for _, v := range rfc6238Vectors {
+		now := time.Unix(v.unix, 0)
+		code := v.code[len(v.code)-totpDigits:]
+		if step := matchTOTP(secret, code, now); step != v.unix/totpPeriod {
+			t.Errorf("T=%d: step %d, want %d", v.unix, step, v.unix/totpPeriod)
+		}
+	}
+
+	now := time.Unix(1111111111, 0)
+	code := "050471"
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		at     time.Time
+		want   int64
+	}{
+		{"lowercase secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code, now, now.Unix() / totpPeriod},
+		{"one step late", secret, code, now.Add(totpPeriod * time.Second), now.Unix() / totpPeriod},
+		{"one step early", secret, code, now.Add(-totpPeriod * time.Second), now.Unix() / totpPeriod},
+		{"two steps late", secret, code, now.Add(2 * totpPeriod * time.Second), -1},
+		{"wrong code", secret, "050472", now, -1},
+		{"8 digits", secret, "14050471", now, -1},
+		{"bad secret", "not base32!", code, now, -1},
+	}
+	for _, tt := range tests {
+		if got := matchTOTP(tt.secret, tt.code, tt.at); got != tt.want {
+			t.Errorf("%s: step %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	if got := normalizeCode(" 123 456 "); got != "123456" {
+		t.Errorf("normalizeCode = %q", got)
+	}
+	if got := normalizeCode("ABCDE-fghij"); got != "abcdefghij" {
+		t.Errorf("normalizeCode = %q", got)
+	}
+}
+
+func TestTOTPLockoutAfter(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{maxTOTPFailures - 1, 0},
+		{maxTOTPFailures, totpLockout},
+		{maxTOTPFailures + 1, 2 * totpLockout},
+		{maxTOTPFailures + 3, 8 * totpLockout},
+		{maxTOTPFailures + 100, maxTOTPLockout},
+	}
+	for _, tt := range tests {
+		if got := totpLockoutAfter(tt.failures); got != tt.want {
+			t.Errorf("%d failures: %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+// Wrong codes count against the user, not the challenge, and only a valid
+// code resets the count
+func TestSecondFactorLockout(t *testing.T) {
+	db := testDB(t,
+		`CREATE TEMP TABLE users (
+			id INT PRIMARY KEY,
+			totp_secret TEXT,
+			totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+			totp_last_step BIGINT NOT NULL DEFAULT 0,
+			totp_failures INT NOT NULL DEFAULT 0,
+			totp_locked_until TIMESTAMP
+		)`,
+		`CREATE TEMP TABLE recovery_codes (
+			id SERIAL PRIMARY KEY,
+			user_id INT,
+			code_hash TEXT NOT NULL,
+			used_at TIMESTAMP
+		)`,
+	)
+	if _, err := db.Exec(`INSERT INTO users (id, totp_secret, totp_enabled) VALUES (1, $1, TRUE)`,
+		base32NoPad.EncodeToString(rfc6238Secret)); err != nil {
+		t.Fatal(err)
+	}
+	verify := func(code, recoveryCode string) (bool, error) {
+		t.Helper()
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Commit()
+		return verifySecondFactor(tx, 1, code, recoveryCode)
+	}
+
+	for i := 0; i < maxTOTPFailures; i++ {
+		recoveryCode := ""
+		if i%2 == 1 {
+			recoveryCode = "aaaaa-bbbbb"
+		}
+		if ok, err := verify("000000", recoveryCode); ok || err != nil {
+			t.Fatalf("wrong code %d: ok %v, err %v", i, ok, err)
+		}
+	}
+	valid := totpCode(rfc6238Secret, time.Now().Unix()/totpPeriod)
+	if _, err := verify(valid, ""); err != errTOTPLocked {
+		t.Fatalf("valid code while locked: err = %v, want errTOTPLocked", err)
+	}
+
+	// Once the lockout has passed a valid code works and clears the count
+	if _, err := db.Exec(`UPDATE users SET totp_locked_until=$1`, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := verify(valid, ""); !ok || err != nil {
+		t.Fatalf("valid code after the lockout: ok %v, err %v", ok, err)
+	}
+	var failures int
+	var lockedUntil *time.Time
+	if err := db.QueryRow(`SELECT totp_failures, totp_locked_until FROM users WHERE id=1`).Scan(&failures, &lockedUntil); err != nil {
+		t.Fatal(err)
+	}
+	if failures != 0 || lockedUntil != nil {
+		t.Errorf("after a valid code: %d failures, locked until %v", failures, lockedUntil)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"net/http"
 
+	"notes-backend/internal/events"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -27,20 +29,42 @@ func CreateCategoryHandler(db *sql.DB) gin.HandlerFunc {
 
 		userID := c.GetInt("userID") // from JWT middleware
 
-		var id int
-		err := db.QueryRow(
-			"INSERT INTO categories (user_id, name) VALUES ($1, $2) RETURNING id",
-			userID, input.Name,
-		).Scan(&id)
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create category"})
+			return
+		}
+		defer tx.Rollback()
+
+		id, err := CreateCategory(tx, userID, input.Name)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create category"})
 			return
 		}
 
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create category"})
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{"id": id, "name": input.Name})
 	}
 }
 
+// CreateCategory adds a category for the user and returns its id
+func CreateCategory(tx *sql.Tx, userID int, name string) (int, error) {
+	var id int
+	err := tx.QueryRow(
+		"INSERT INTO categories (user_id, name) VALUES ($1, $2) RETURNING id",
+		userID, name,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, events.Publish(tx, userID, "category", id, events.Created)
+}
+
 // ListCategoriesHandler - GET /categories
 func ListCategoriesHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -73,33 +97,101 @@ func DeleteCategoryHandler(db *sql.DB) gin.HandlerFunc {
 		userID := c.GetInt("userID")
 		categoryID := c.Param("id")
 
-		// Option 1: Soft-delete notes? Or set category_id to null
-		_, err := db.Exec(`
-            UPDATE notes
-            SET category_id = NULL
-            WHERE category_id = $1 AND user_id = $2
-        `, categoryID, userID)
+		tx, err := db.Begin()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notes"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
 			return
 		}
+		defer tx.Rollback()
 
-		// Delete the category itself
-		res, err := db.Exec(`
-            DELETE FROM categories
-            WHERE id=$1 AND user_id=$2
-        `, categoryID, userID)
+		found, err := DeleteCategory(tx, userID, categoryID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
 			return
 		}
-
-		rowsAffected, _ := res.RowsAffected()
-		if rowsAffected == 0 {
+		if !found {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found or not owned by user"})
 			return
 		}
 
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{"message": "Category deleted successfully"})
 	}
 }
+
+// DeleteCategory removes one of the user's categories, moving its notes to
+// no category. It reports false if there was no such category.
+func DeleteCategory(tx *sql.Tx, userID int, categoryID interface{}) (bool, error) {
+	// Option 1: Soft-delete notes? Or set category_id to null
+	rows, err := tx.Query(`
+		UPDATE notes
+		SET category_id = NULL
+		WHERE category_id = $1 AND user_id = $2
+		RETURNING id
+	`, categoryID, userID)
+	if err != nil {
+		return false, err
+	}
+	var noteIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return false, err
+		}
+		noteIDs = append(noteIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	// Delete the category itself
+	res, err := tx.Exec(`
+		DELETE FROM categories
+		WHERE id=$1 AND user_id=$2
+	`, categoryID, userID)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		return false, nil
+	}
+
+	for _, id := range noteIDs {
+		if err := events.PublishNote(tx, id, "note", id, events.Updated); err != nil {
+			return false, err
+		}
+	}
+	return true, events.Publish(tx, userID, "category", categoryID, events.Deleted)
+}
+
+// FindOrCreateCategory returns the id of the user's category with this name,
+// creating it if needed. Used by the importers.
+func FindOrCreateCategory(tx *sql.Tx, userID int, name string) (int, error) {
+	var id int
+	err := tx.QueryRow(
+		"SELECT id FROM categories WHERE user_id=$1 AND name=$2 ORDER BY id LIMIT 1",
+		userID, name,
+	).Scan(&id)
+	if err == nil {
+		return id, nil
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	err = tx.QueryRow(
+		"INSERT INTO categories (user_id, name) VALUES ($1, $2) RETURNING id",
+		userID, name,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, events.Publish(tx, userID, "category", id, events.Created)
+}
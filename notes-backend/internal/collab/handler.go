@@ -0,0 +1,202 @@
+package collab
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"notes-backend/internal/sharing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	maxMessageSize = 1 << 20
+	sendBuffer     = 256
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingInterval   = pongWait * 9 / 10
+)
+
+// newUpgrader accepts sockets opened by pages from the given origins, the
+// ones CORS allows, so no other site can use a ticket to join on a user's
+// behalf. Clients that aren't browsers send no Origin and are let through.
+func newUpgrader(origins []string) *websocket.Upgrader {
+	allowed := map[string]bool{}
+	for _, o := range origins {
+		allowed[o] = true
+	}
+	return &websocket.Upgrader{
+		ReadBufferSize:  4096,
+		WriteBufferSize: 4096,
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || allowed[origin]
+		},
+	}
+}
+
+// inbound is a message from a client:
+//
+//	{"type": "op", "revision": 12, "op": [5, "hello", -3, 12]}
+//	{"type": "cursor", "revision": 12, "cursor": {"anchor": 3, "head": 7}}
+type inbound struct {
+	Type     string    `json:"type"`
+	Revision int       `json:"revision"`
+	Op       Operation `json:"op"`
+	Cursor   *Cursor   `json:"cursor"`
+}
+
+// LiveHandler - GET /notes/:id/live
+// Upgrades to a WebSocket for editing the note body together with everyone
+// it is shared with. The server answers with "init" (body, revision and who
+// is connected), "ack" for the client's own operations, and "op", "cursor",
+// "join", "leave", "role", "closed" and "error" messages. Viewers are
+// read-only. Access is checked again every few seconds: a client whose
+// share is revoked gets "closed", and "role" tells everyone when a client's
+// read_only changes. Clients also get "closed" when the access token they
+// connected with expires or is revoked. Sockets are only accepted from
+// allowedOrigins.
+func LiveHandler(db *sql.DB, m *Manager, allowedOrigins []string) gin.HandlerFunc {
+	upgrader := newUpgrader(allowedOrigins)
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+		noteID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+			return
+		}
+
+		role, err := sharing.NoteRole(db, noteID, userID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch note"})
+			return
+		}
+
+		var username string
+		if err := db.QueryRow(`SELECT username FROM users WHERE id=$1`, userID).Scan(&username); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+			return
+		}
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// Upgrade has already replied
+			return
+		}
+
+		cl := &client{
+			Presence: Presence{
+				ClientID: newClientID(),
+				UserID:   userID,
+				Username: username,
+				ReadOnly: !sharing.CanEdit(role),
+			},
+			conn: conn,
+			send: make(chan []byte, sendBuffer),
+		}
+		go cl.writePump()
+
+		s, err := m.join(noteID, cl)
+		if err != nil {
+			cl.push(gin.H{"type": "error", "error": "Note not found"})
+			close(cl.send)
+			return
+		}
+		defer m.leave(s, cl)
+
+		// The socket outlives the request, so watch for the request's
+		// context ending with the session it was opened with
+		// (see middleware.StreamAuthMiddleware)
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-done:
+			case <-c.Request.Context().Done():
+				s.disconnect(cl, "Your session has ended, log in again")
+			}
+		}()
+
+		conn.SetReadLimit(maxMessageSize)
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(pongWait))
+		})
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(pongWait))
+
+			var msg inbound
+			if err := json.Unmarshal(data, &msg); err != nil {
+				cl.push(gin.H{"type": "error", "error": "Invalid message"})
+				continue
+			}
+
+			switch msg.Type {
+			case "op":
+				err := s.edit(cl, msg.Revision, msg.Op)
+				if err == errReadOnly {
+					cl.push(gin.H{"type": "error", "error": "Viewers cannot edit this note"})
+					continue
+				} else if err != nil {
+					// The client is out of sync; it reconnects for a fresh copy
+					cl.push(gin.H{"type": "error", "error": "Operation rejected: " + err.Error()})
+					return
+				}
+			case "cursor":
+				if err := s.moveCursor(cl, msg.Revision, msg.Cursor); err != nil {
+					cl.push(gin.H{"type": "error", "error": "Cursor rejected: " + err.Error()})
+				}
+			default:
+				cl.push(gin.H{"type": "error", "error": "Unknown message type"})
+			}
+		}
+	}
+}
+
+// writePump is the only writer on the socket
+func (c *client) writePump() {
+	ping := time.NewTicker(pingInterval)
+	defer func() {
+		ping.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case data, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok || data == nil {
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ping.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func newClientID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,29 @@
+package collab
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpgraderCheckOrigin(t *testing.T) {
+	u := newUpgrader([]string{"http://localhost:3000"})
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"http://localhost:3000", true},
+		{"", true}, // not a browser
+		{"https://evil.example", false},
+		{"http://localhost:3000.evil.example", false},
+		{"http://localhost:8080", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/notes/1/live", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := u.CheckOrigin(r); got != tt.want {
+			t.Errorf("Origin %q: allowed %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
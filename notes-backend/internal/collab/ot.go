@@ -0,0 +1,277 @@
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Operation is a text edit in the ot.js wire format: a positive integer
+// retains that many characters, a negative integer deletes them and a string
+// is inserted. Lengths count UTF-16 code units, like JavaScript strings, so
+// browser clients can use their editor's offsets as-is.
+//
+//	[5, "hello", -3, 12]
+type Operation []component
+
+// component is one step of an operation: n > 0 retains, n < 0 deletes and
+// n == 0 inserts s.
+type component struct {
+	n int
+	s string
+}
+
+var errLengthMismatch = errors.New("operation does not match the document length")
+
+func textLen(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+func (o *Operation) retain(n int) {
+	if n <= 0 {
+		return
+	}
+	if last := len(*o) - 1; last >= 0 && (*o)[last].n > 0 {
+		(*o)[last].n += n
+		return
+	}
+	*o = append(*o, component{n: n})
+}
+
+func (o *Operation) insert(s string) {
+	if s == "" {
+		return
+	}
+	ops := *o
+	last := len(ops) - 1
+	switch {
+	case last >= 0 && ops[last].n == 0:
+		ops[last].s += s
+	case last >= 0 && ops[last].n < 0:
+		// Keep inserts before deletes so equal edits have one representation
+		if last > 0 && ops[last-1].n == 0 {
+			ops[last-1].s += s
+		} else {
+			ops = append(ops, ops[last])
+			ops[last] = component{s: s}
+		}
+	default:
+		ops = append(ops, component{s: s})
+	}
+	*o = ops
+}
+
+func (o *Operation) delete(n int) {
+	if n <= 0 {
+		return
+	}
+	if last := len(*o) - 1; last >= 0 && (*o)[last].n < 0 {
+		(*o)[last].n -= n
+		return
+	}
+	*o = append(*o, component{n: -n})
+}
+
+// baseLen is the length of the document the operation applies to
+func (o Operation) baseLen() int {
+	l := 0
+	for _, c := range o {
+		if c.n > 0 {
+			l += c.n
+		} else if c.n < 0 {
+			l -= c.n
+		}
+	}
+	return l
+}
+
+// apply runs the operation over a document held as UTF-16 code units
+func (o Operation) apply(doc []uint16) ([]uint16, error) {
+	if o.baseLen() != len(doc) {
+		return nil, errLengthMismatch
+	}
+	out := make([]uint16, 0, len(doc))
+	i := 0
+	for _, c := range o {
+		switch {
+		case c.n > 0:
+			out = append(out, doc[i:i+c.n]...)
+			i += c.n
+		case c.n < 0:
+			i -= c.n
+		default:
+			out = append(out, utf16.Encode([]rune(c.s))...)
+		}
+	}
+	return out, nil
+}
+
+// transform takes two operations made concurrently on the same document and
+// returns a' and b' such that applying a then b' equals applying b then a'.
+// Inserts at the same position put a's text first.
+func transform(a, b Operation) (Operation, Operation, error) {
+	if a.baseLen() != b.baseLen() {
+		return nil, nil, errLengthMismatch
+	}
+
+	var a1, b1 Operation
+	i, j := 0, 0
+	var op1, op2 *component
+	next := func(ops Operation, k *int) *component {
+		if *k >= len(ops) {
+			return nil
+		}
+		c := ops[*k]
+		*k++
+		return &c
+	}
+	op1, op2 = next(a, &i), next(b, &j)
+
+	for op1 != nil || op2 != nil {
+		if op1 != nil && op1.n == 0 {
+			a1.insert(op1.s)
+			b1.retain(textLen(op1.s))
+			op1 = next(a, &i)
+			continue
+		}
+		if op2 != nil && op2.n == 0 {
+			a1.retain(textLen(op2.s))
+			b1.insert(op2.s)
+			op2 = next(b, &j)
+			continue
+		}
+		if op1 == nil || op2 == nil {
+			return nil, nil, errLengthMismatch
+		}
+
+		switch {
+		case op1.n > 0 && op2.n > 0:
+			min := minInt(op1.n, op2.n)
+			a1.retain(min)
+			b1.retain(min)
+			op1.n -= min
+			op2.n -= min
+		case op1.n < 0 && op2.n < 0:
+			// Both deleted the same text
+			min := minInt(-op1.n, -op2.n)
+			op1.n += min
+			op2.n += min
+		case op1.n < 0 && op2.n > 0:
+			min := minInt(-op1.n, op2.n)
+			a1.delete(min)
+			op1.n += min
+			op2.n -= min
+		case op1.n > 0 && op2.n < 0:
+			min := minInt(op1.n, -op2.n)
+			b1.delete(min)
+			op1.n -= min
+			op2.n += min
+		}
+		if op1.n == 0 {
+			op1 = next(a, &i)
+		}
+		if op2.n == 0 {
+			op2 = next(b, &j)
+		}
+	}
+	return a1, b1, nil
+}
+
+// transformIndex moves a cursor position past an operation
+func transformIndex(index int, o Operation) int {
+	newIndex := index
+	for _, c := range o {
+		switch {
+		case c.n > 0:
+			index -= c.n
+		case c.n == 0:
+			newIndex += textLen(c.s)
+		default:
+			newIndex -= minInt(index, -c.n)
+			index += c.n
+		}
+		if index < 0 {
+			break
+		}
+	}
+	return newIndex
+}
+
+// diffOperation builds an operation turning old into new as a single
+// replacement of the part between their common prefix and suffix.
+func diffOperation(old, new []uint16) Operation {
+	prefix := 0
+	for prefix < len(old) && prefix < len(new) && old[prefix] == new[prefix] {
+		prefix++
+	}
+	// Don't split a surrogate pair: characters outside the BMP that share
+	// their first or last code unit would be cut in half
+	if prefix > 0 && isHighSurrogate(old[prefix-1]) {
+		prefix--
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(new)-prefix &&
+		old[len(old)-1-suffix] == new[len(new)-1-suffix] {
+		suffix++
+	}
+	if suffix > 0 && isLowSurrogate(old[len(old)-suffix]) {
+		suffix--
+	}
+
+	var o Operation
+	o.retain(prefix)
+	o.insert(string(utf16.Decode(new[prefix : len(new)-suffix])))
+	o.delete(len(old) - prefix - suffix)
+	o.retain(suffix)
+	return o
+}
+
+func isHighSurrogate(u uint16) bool { return u >= 0xD800 && u < 0xDC00 }
+
+func isLowSurrogate(u uint16) bool { return u >= 0xDC00 && u < 0xE000 }
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (o Operation) MarshalJSON() ([]byte, error) {
+	out := make([]interface{}, len(o))
+	for i, c := range o {
+		if c.n == 0 {
+			out[i] = c.s
+		} else {
+			out[i] = c.n
+		}
+	}
+	return json.Marshal(out)
+}
+
+func (o *Operation) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	var op Operation
+	for _, r := range raw {
+		var s string
+		if err := json.Unmarshal(r, &s); err == nil {
+			op.insert(s)
+			continue
+		}
+		var n int
+		if err := json.Unmarshal(r, &n); err != nil || n == 0 {
+			return fmt.Errorf("invalid operation component %s", r)
+		}
+		if n > 0 {
+			op.retain(n)
+		} else {
+			op.delete(-n)
+		}
+	}
+	*o = op
+	return nil
+}
@@ -0,0 +1,206 @@
+package collab
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+	"unicode/utf16"
+)
+
+func parseOp(t *testing.T, s string) Operation {
+	t.Helper()
+	var o Operation
+	if err := json.Unmarshal([]byte(s), &o); err != nil {
+		t.Fatalf("parsing %s: %v", s, err)
+	}
+	return o
+}
+
+func doc(s string) []uint16 { return utf16.Encode([]rune(s)) }
+
+func text(d []uint16) string { return string(utf16.Decode(d)) }
+
+func TestOperationJSON(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{`[5,"hello",-3,12]`, `[5,"hello",-3,12]`},
+		// Adjacent components of a kind are merged, empty inserts dropped
+		{`[2,3,"a","",-1,-1]`, `[5,"a",-2]`},
+		// Inserts go before deletes
+		{`[1,-2,"x"]`, `[1,"x",-2]`},
+	}
+	for _, tt := range tests {
+		b, err := json.Marshal(parseOp(t, tt.in))
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("%s round-tripped to %s, want %s", tt.in, b, tt.want)
+		}
+	}
+
+	for _, bad := range []string{`[0]`, `[1.5]`, `[true]`, `{"a":1}`} {
+		var o Operation
+		if err := json.Unmarshal([]byte(bad), &o); err == nil {
+			t.Errorf("%s was accepted", bad)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		doc, op, want string
+	}{
+		{"hello world", `[6,"there ",-5]`, "hello there "},
+		{"", `["new"]`, "new"},
+		{"abc", `[-3]`, ""},
+		// Lengths are UTF-16 code units: the emoji counts as 2
+		{"a😀b", `[3,"!",1]`, "a😀!b"},
+		{"a😀b", `[1,-2,1]`, "ab"},
+	}
+	for _, tt := range tests {
+		got, err := parseOp(t, tt.op).apply(doc(tt.doc))
+		if err != nil {
+			t.Errorf("applying %s to %q: %v", tt.op, tt.doc, err)
+			continue
+		}
+		if text(got) != tt.want {
+			t.Errorf("applying %s to %q = %q, want %q", tt.op, tt.doc, text(got), tt.want)
+		}
+	}
+
+	if _, err := parseOp(t, `[3]`).apply(doc("ab")); err != errLengthMismatch {
+		t.Errorf("too long an operation: err = %v", err)
+	}
+	if _, err := parseOp(t, `[1]`).apply(doc("ab")); err != errLengthMismatch {
+		t.Errorf("too short an operation: err = %v", err)
+	}
+}
+
+// transform must make both orders of applying concurrent operations
+// end at the same document
+func checkConverges(t *testing.T, base string, a, b Operation) string {
+	t.Helper()
+	a1, b1, err := transform(a, b)
+	if err != nil {
+		t.Fatalf("transform: %v", err)
+	}
+	d := doc(base)
+	da, err := a.apply(d)
+	if err != nil {
+		t.Fatalf("a: %v", err)
+	}
+	dab, err := b1.apply(da)
+	if err != nil {
+		t.Fatalf("b': %v", err)
+	}
+	db, err := b.apply(d)
+	if err != nil {
+		t.Fatalf("b: %v", err)
+	}
+	dba, err := a1.apply(db)
+	if err != nil {
+		t.Fatalf("a': %v", err)
+	}
+	if text(dab) != text(dba) {
+		t.Fatalf("a then b' = %q, b then a' = %q", text(dab), text(dba))
+	}
+	return text(dab)
+}
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		name, doc, a, b, want string
+	}{
+		{"inserts in different places", "abc", `["X",3]`, `[3,"Y"]`, "XabcY"},
+		{"inserts at the same place put a first", "abc", `[1,"X",2]`, `[1,"Y",2]`, "aXYbc"},
+		{"same deletion", "abcdef", `[1,-3,2]`, `[1,-3,2]`, "aef"},
+		{"overlapping deletions", "abcdef", `[1,-3,2]`, `[2,-3,1]`, "af"},
+		{"insert inside a deletion", "abcdef", `[2,"X",4]`, `[1,-4,1]`, "aXf"},
+		{"delete everything while typing", "abc", `[-3]`, `[3,"!"]`, "!"},
+		{"surrogate pairs", "😀😀", `[2,"a",2]`, `[-2,2]`, "a😀"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkConverges(t, tt.doc, parseOp(t, tt.a), parseOp(t, tt.b))
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, _, err := transform(parseOp(t, `[3]`), parseOp(t, `[2]`)); err != errLengthMismatch {
+		t.Errorf("operations on different lengths: err = %v", err)
+	}
+}
+
+// randomOp makes an operation over a document of length n
+func randomOp(r *rand.Rand, n int) Operation {
+	var o Operation
+	for n > 0 {
+		k := 1 + r.Intn(n)
+		switch r.Intn(3) {
+		case 0:
+			o.retain(k)
+			n -= k
+		case 1:
+			o.delete(k)
+			n -= k
+		default:
+			o.insert(string(rune('a' + r.Intn(26))))
+		}
+	}
+	if r.Intn(2) == 0 {
+		o.insert("z")
+	}
+	return o
+}
+
+func TestTransformRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		base := make([]rune, r.Intn(20))
+		for j := range base {
+			base[j] = rune('A' + r.Intn(26))
+		}
+		n := len(base)
+		checkConverges(t, string(base), randomOp(r, n), randomOp(r, n))
+	}
+}
+
+func TestTransformIndex(t *testing.T) {
+	op := parseOp(t, `[2,"XY",-2,2]`) // "abcdef" -> "abXYef"
+	tests := []struct{ in, want int }{
+		{0, 0},
+		{2, 4}, // inserts at the cursor push it along
+		{3, 4}, // inside the deleted text
+		{4, 4},
+		{5, 5},
+		{6, 6},
+	}
+	for _, tt := range tests {
+		if got := transformIndex(tt.in, op); got != tt.want {
+			t.Errorf("transformIndex(%d) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDiffOperation(t *testing.T) {
+	tests := []struct{ old, new, want string }{
+		{"hello world", "hello there world", `[6,"there ",5]`},
+		{"abc", "abc", `[3]`},
+		{"abc", "", `[-3]`},
+		{"", "abc", `["abc"]`},
+		{"a😀b", "a😃b", `[1,"😃",-2,1]`},
+	}
+	for _, tt := range tests {
+		o := diffOperation(doc(tt.old), doc(tt.new))
+		b, _ := json.Marshal(o)
+		if string(b) != tt.want {
+			t.Errorf("diffOperation(%q, %q) = %s, want %s", tt.old, tt.new, b, tt.want)
+		}
+		got, err := o.apply(doc(tt.old))
+		if err != nil || text(got) != tt.new {
+			t.Errorf("applying diffOperation(%q, %q) gave %q, %v", tt.old, tt.new, text(got), err)
+		}
+	}
+}
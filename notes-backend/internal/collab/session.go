@@ -0,0 +1,458 @@
+package collab
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"notes-backend/internal/notes"
+	"notes-backend/internal/sharing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// How often a session with unsaved edits is written back to the notes row
+const checkpointInterval = 5 * time.Second
+
+var (
+	errBadRevision = errors.New("unknown revision")
+	errReadOnly    = errors.New("read-only")
+)
+
+// Cursor is a selection in UTF-16 offsets; anchor == head for a caret
+type Cursor struct {
+	Anchor int `json:"anchor"`
+	Head   int `json:"head"`
+}
+
+// Presence describes someone connected to a note
+type Presence struct {
+	ClientID string  `json:"client_id"`
+	UserID   int     `json:"user_id"`
+	Username string  `json:"username"`
+	ReadOnly bool    `json:"read_only"`
+	Cursor   *Cursor `json:"cursor,omitempty"`
+}
+
+type client struct {
+	Presence
+	conn *websocket.Conn
+	send chan []byte // a nil message asks the writer to close the socket
+}
+
+// push queues a message without blocking; a client that can't keep up is
+// disconnected and has to rejoin.
+func (c *client) push(msg gin.H) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	c.queue(data)
+}
+
+func (c *client) queue(data []byte) {
+	select {
+	case c.send <- data:
+	default:
+		c.conn.Close()
+	}
+}
+
+// Manager keeps one editing session per note that has someone connected.
+// Sessions live in memory, so all editors of a note have to reach the same
+// instance; edits saved through the REST API are merged at checkpoints.
+type Manager struct {
+	db       *sql.DB
+	mu       sync.Mutex
+	sessions map[int]*session
+	closing  map[int]chan struct{} // sessions writing their final checkpoint
+}
+
+func NewManager(db *sql.DB) *Manager {
+	return &Manager{db: db, sessions: map[int]*session{}, closing: map[int]chan struct{}{}}
+}
+
+// join adds a client to the note's session, starting one if needed
+func (m *Manager) join(noteID int, c *client) (*session, error) {
+	for {
+		m.mu.Lock()
+		done, ok := m.closing[noteID]
+		if !ok {
+			break
+		}
+		// Wait for the last session to save so we don't load a stale body
+		m.mu.Unlock()
+		<-done
+	}
+	defer m.mu.Unlock()
+
+	s := m.sessions[noteID]
+	if s == nil {
+		var err error
+		s, err = loadSession(m.db, noteID)
+		if err != nil {
+			return nil, err
+		}
+		m.sessions[noteID] = s
+		go s.run()
+	}
+	if err := s.add(c); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// leave removes a client; the last one out saves the document and ends
+// the session.
+func (m *Manager) leave(s *session, c *client) {
+	m.mu.Lock()
+	s.mu.Lock()
+	delete(s.clients, c)
+	close(c.send)
+	empty := len(s.clients) == 0
+	if !empty {
+		s.broadcast(nil, gin.H{"type": "leave", "client_id": c.ClientID})
+	}
+	s.mu.Unlock()
+
+	var done chan struct{}
+	if empty {
+		delete(m.sessions, s.noteID)
+		done = make(chan struct{})
+		m.closing[s.noteID] = done
+	}
+	m.mu.Unlock()
+
+	if !empty {
+		return
+	}
+	close(s.stop)
+	s.checkpoint(true)
+
+	m.mu.Lock()
+	delete(m.closing, s.noteID)
+	m.mu.Unlock()
+	close(done)
+}
+
+// session is the live copy of one note's body. Every accepted operation
+// gets the next revision number; clients send the revision their operation
+// was made against and it is transformed past everything they hadn't seen.
+type session struct {
+	noteID int
+	db     *sql.DB
+	stop   chan struct{}
+
+	mu      sync.Mutex
+	doc     []uint16
+	history []Operation // history[r] moves the document from revision r to r+1
+	clients map[*client]struct{}
+	closed  bool
+
+	// What the notes row held at the last checkpoint
+	saveMu       sync.Mutex
+	savedBody    []uint16
+	savedRev     int
+	savedVersion int
+	unrevisioned bool
+}
+
+func loadSession(db *sql.DB, noteID int) (*session, error) {
+	var body string
+	var version int
+	err := db.QueryRow(`
+		SELECT COALESCE(body, ''), version FROM notes WHERE id=$1 AND deleted_at IS NULL
+	`, noteID).Scan(&body, &version)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := utf16.Encode([]rune(body))
+	return &session{
+		noteID:       noteID,
+		db:           db,
+		stop:         make(chan struct{}),
+		doc:          doc,
+		clients:      map[*client]struct{}{},
+		savedBody:    doc,
+		savedVersion: version,
+	}, nil
+}
+
+func (s *session) run() {
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.checkpoint(false)
+			s.checkAccess()
+		}
+	}
+}
+
+func (s *session) add(c *client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return sql.ErrNoRows
+	}
+
+	others := []Presence{}
+	for other := range s.clients {
+		others = append(others, other.Presence)
+	}
+	s.clients[c] = struct{}{}
+
+	c.push(gin.H{
+		"type":      "init",
+		"client_id": c.ClientID,
+		"read_only": c.ReadOnly,
+		"revision":  len(s.history),
+		"body":      string(utf16.Decode(s.doc)),
+		"clients":   others,
+	})
+	s.broadcast(c, gin.H{"type": "join", "client": c.Presence})
+	return nil
+}
+
+// broadcast sends a message to every client but one. Callers hold s.mu.
+func (s *session) broadcast(except *client, msg gin.H) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	for c := range s.clients {
+		if c != except {
+			c.queue(data)
+		}
+	}
+}
+
+// apply transforms an operation made at revision rev past the ones accepted
+// since, applies it and moves everyone's cursors. Callers hold s.mu.
+func (s *session) apply(rev int, op Operation) (Operation, error) {
+	if rev < 0 || rev > len(s.history) {
+		return nil, errBadRevision
+	}
+	for _, concurrent := range s.history[rev:] {
+		var err error
+		op, _, err = transform(op, concurrent)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	doc, err := op.apply(s.doc)
+	if err != nil {
+		return nil, err
+	}
+	s.doc = doc
+	s.history = append(s.history, op)
+
+	for c := range s.clients {
+		if c.Cursor != nil {
+			c.Cursor = &Cursor{
+				Anchor: transformIndex(c.Cursor.Anchor, op),
+				Head:   transformIndex(c.Cursor.Head, op),
+			}
+		}
+	}
+	return op, nil
+}
+
+// edit applies a client's operation, acknowledges it to the sender and
+// sends the transformed operation to everyone else.
+func (s *session) edit(c *client, rev int, op Operation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c.ReadOnly {
+		return errReadOnly
+	}
+	op, err := s.apply(rev, op)
+	if err != nil {
+		return err
+	}
+
+	revision := len(s.history)
+	c.push(gin.H{"type": "ack", "revision": revision})
+	s.broadcast(c, gin.H{"type": "op", "client_id": c.ClientID, "revision": revision, "op": op})
+	return nil
+}
+
+// moveCursor records a client's selection, made at revision rev, and shares it
+func (s *session) moveCursor(c *client, rev int, cursor *Cursor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cursor != nil {
+		if rev < 0 || rev > len(s.history) {
+			return errBadRevision
+		}
+		cur := *cursor
+		for _, op := range s.history[rev:] {
+			cur.Anchor = transformIndex(cur.Anchor, op)
+			cur.Head = transformIndex(cur.Head, op)
+		}
+		cur.Anchor = clamp(cur.Anchor, len(s.doc))
+		cur.Head = clamp(cur.Head, len(s.doc))
+		cursor = &cur
+	}
+	c.Cursor = cursor
+
+	s.broadcast(c, gin.H{"type": "cursor", "client_id": c.ClientID, "revision": len(s.history), "cursor": cursor})
+	return nil
+}
+
+func clamp(i, max int) int {
+	if i < 0 {
+		return 0
+	}
+	if i > max {
+		return max
+	}
+	return i
+}
+
+// checkpoint writes the document back to the notes row. If the body was
+// changed through the REST API since the last checkpoint, that change is
+// merged in first as an edit made at the last checkpoint's revision. The
+// final checkpoint also records a revision.
+func (s *session) checkpoint(final bool) {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		fmt.Printf("❌ Failed to checkpoint note %d: %v\n", s.noteID, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var dbBody string
+	var version int
+	var deleted bool
+	err = tx.QueryRow(`
+		SELECT COALESCE(body, ''), version, deleted_at IS NOT NULL
+		FROM notes WHERE id=$1
+		FOR UPDATE
+	`, s.noteID).Scan(&dbBody, &version, &deleted)
+	if err == sql.ErrNoRows || (err == nil && deleted) {
+		s.close("Note was deleted")
+		return
+	} else if err != nil {
+		fmt.Printf("❌ Failed to checkpoint note %d: %v\n", s.noteID, err)
+		return
+	}
+
+	s.mu.Lock()
+	if version != s.savedVersion {
+		if dbBody != string(utf16.Decode(s.savedBody)) {
+			external := utf16.Encode([]rune(dbBody))
+			op, err := s.apply(s.savedRev, diffOperation(s.savedBody, external))
+			if err != nil {
+				fmt.Printf("❌ Failed to merge saved body of note %d: %v\n", s.noteID, err)
+			} else {
+				s.broadcast(nil, gin.H{"type": "op", "client_id": "", "revision": len(s.history), "op": op})
+			}
+		}
+	}
+	doc := s.doc
+	rev := len(s.history)
+	s.mu.Unlock()
+
+	body := string(utf16.Decode(doc))
+	if body == dbBody && !(final && s.unrevisioned) {
+		s.savedBody, s.savedRev, s.savedVersion = doc, rev, version
+		return
+	}
+
+	newVersion, err := notes.SaveBody(tx, s.noteID, body, final)
+	if err != nil {
+		fmt.Printf("❌ Failed to checkpoint note %d: %v\n", s.noteID, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		fmt.Printf("❌ Failed to checkpoint note %d: %v\n", s.noteID, err)
+		return
+	}
+	s.savedBody, s.savedRev, s.savedVersion = doc, rev, newVersion
+	s.unrevisioned = !final
+}
+
+// checkAccess looks up the role of everyone connected again, since shares
+// can be revoked or changed, and the note made private, while sockets stay
+// open. Clients who lost access are disconnected; the others are told
+// when they become read-only or may edit again.
+func (s *session) checkAccess() {
+	s.mu.Lock()
+	users := map[int]struct{}{}
+	for c := range s.clients {
+		users[c.UserID] = struct{}{}
+	}
+	s.mu.Unlock()
+
+	roles := map[int]string{}
+	for userID := range users {
+		role, err := sharing.NoteRole(s.db, s.noteID, userID)
+		if err != nil && err != sql.ErrNoRows {
+			fmt.Printf("❌ Failed to check access to note %d: %v\n", s.noteID, err)
+			return
+		}
+		roles[userID] = role // empty without access
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	for c := range s.clients {
+		role, ok := roles[c.UserID]
+		if !ok {
+			continue // joined since, with a fresh role
+		}
+		if role == "" {
+			c.push(gin.H{"type": "closed", "reason": "You no longer have access to this note"})
+			c.queue(nil)
+			continue
+		}
+		if readOnly := !sharing.CanEdit(role); readOnly != c.ReadOnly {
+			c.ReadOnly = readOnly
+			s.broadcast(nil, gin.H{"type": "role", "client_id": c.ClientID, "read_only": readOnly})
+		}
+	}
+}
+
+// disconnect ends the session for one client, unless it has already left
+func (s *session) disconnect(c *client, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[c]; !ok || s.closed {
+		return
+	}
+	c.push(gin.H{"type": "closed", "reason": reason})
+	c.queue(nil)
+}
+
+// close ends the session for everyone, e.g. when the note is deleted
+func (s *session) close(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.broadcast(nil, gin.H{"type": "closed", "reason": reason})
+	for c := range s.clients {
+		c.queue(nil)
+	}
+}
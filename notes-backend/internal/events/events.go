@@ -0,0 +1,227 @@
+package events
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Channel is the Postgres NOTIFY channel events are fanned out on
+const Channel = "note_events"
+
+// Actions
+const (
+	Created  = "created"
+	Updated  = "updated"
+	Deleted  = "deleted"
+	Restored = "restored"
+)
+
+// How long events are kept for Last-Event-ID replay
+const retention = 7 * 24 * time.Hour
+
+// Event is a change to one of a user's notes, categories or images
+type Event struct {
+	ID        int64  `json:"id"`
+	UserID    int    `json:"user_id"`
+	Entity    string `json:"entity"` // "note", "category", "image"
+	EntityID  int    `json:"entity_id"`
+	NoteID    *int   `json:"note_id,omitempty"`
+	Action    string `json:"action"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Execer is satisfied by both *sql.DB and *sql.Tx. Publishing inside a
+// transaction means the event is only delivered if the change commits.
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// lockClass namespaces the advisory locks taken on users while publishing
+const lockClass = 1001
+
+const notifyInserted = `
+	SELECT pg_notify('` + Channel + `', row_to_json(e)::text) FROM e
+`
+
+// Publish records an event for a user and notifies every backend instance
+func Publish(q Execer, userID int, entity string, entityID interface{}, action string) error {
+	return inTx(q, func(tx Execer) error {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1, $2)`, lockClass, userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+			WITH e AS (
+				INSERT INTO events (user_id, entity, entity_id, action)
+				VALUES ($1, $2, $3, $4)
+				RETURNING id, user_id, entity, entity_id, note_id, action, created_at
+			)`+notifyInserted, userID, entity, entityID, action)
+		return err
+	})
+}
+
+// PublishNote records an event about a note, or something attached to it,
+// for the note's owner and everyone it is shared with.
+func PublishNote(q Execer, noteID interface{}, entity string, entityID interface{}, action string) error {
+	return inTx(q, func(tx Execer) error {
+		_, err := tx.Exec(`
+			SELECT pg_advisory_xact_lock($1, u.user_id)
+			FROM (
+				SELECT user_id FROM notes WHERE id = $2
+				UNION
+				SELECT user_id FROM note_shares WHERE note_id = $2
+				ORDER BY user_id
+			) u
+		`, lockClass, noteID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			WITH e AS (
+				INSERT INTO events (user_id, entity, entity_id, note_id, action)
+				SELECT user_id, $2::text, $3::int, $1::int, $4::text FROM notes WHERE id = $1
+				UNION ALL
+				SELECT user_id, $2::text, $3::int, $1::int, $4::text FROM note_shares WHERE note_id = $1
+				RETURNING id, user_id, entity, entity_id, note_id, action, created_at
+			)`+notifyInserted, noteID, entity, entityID, action)
+		return err
+	})
+}
+
+// inTx runs fn in a transaction, reusing q if it already is one. Events
+// are numbered while holding a per-user lock until commit, so each user's
+// events become visible in id order and an id works as a sync position.
+func inTx(q Execer, fn func(tx Execer) error) error {
+	db, ok := q.(*sql.DB)
+	if !ok {
+		return fn(q)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LogError reports a publish failure outside a transaction; the change
+// itself already happened, so the request still succeeds.
+func LogError(err error) {
+	if err != nil {
+		fmt.Printf("❌ Failed to publish event: %v\n", err)
+	}
+}
+
+// Hub receives notifications from Postgres and hands them to the event
+// streams open on this instance.
+type Hub struct {
+	db   *sql.DB
+	mu   sync.Mutex
+	subs map[int]map[chan Event]struct{}
+}
+
+func NewHub(db *sql.DB) *Hub {
+	return &Hub{db: db, subs: map[int]map[chan Event]struct{}{}}
+}
+
+// Listen starts listening on the notify channel with a dedicated connection
+// and prunes old events in the background.
+func (h *Hub) Listen(dsn string) error {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			fmt.Printf("❌ Event listener: %v\n", err)
+		}
+	})
+	if err := listener.Listen(Channel); err != nil {
+		return err
+	}
+
+	go func() {
+		for n := range listener.Notify {
+			if n == nil {
+				// Reconnected: notifications may have been lost, so make
+				// clients reconnect and replay from their Last-Event-ID
+				h.closeAll()
+				continue
+			}
+			var ev Event
+			if err := json.Unmarshal([]byte(n.Extra), &ev); err != nil {
+				fmt.Printf("❌ Bad event payload: %v\n", err)
+				continue
+			}
+			h.dispatch(ev)
+		}
+	}()
+
+	go func() {
+		for {
+			if _, err := h.db.Exec(`DELETE FROM events WHERE created_at < $1`, time.Now().Add(-retention)); err != nil {
+				fmt.Printf("❌ Failed to prune events: %v\n", err)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+	return nil
+}
+
+func (h *Hub) subscribe(userID int) chan Event {
+	ch := make(chan Event, 64)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[userID] == nil {
+		h.subs[userID] = map[chan Event]struct{}{}
+	}
+	h.subs[userID][ch] = struct{}{}
+	return ch
+}
+
+func (h *Hub) unsubscribe(userID int, ch chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[userID][ch]; ok {
+		delete(h.subs[userID], ch)
+		close(ch)
+	}
+	if len(h.subs[userID]) == 0 {
+		delete(h.subs, userID)
+	}
+}
+
+func (h *Hub) dispatch(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[ev.UserID] {
+		select {
+		case ch <- ev:
+		default:
+			// Too slow to keep up: end its stream, it will resume from the DB
+			delete(h.subs[ev.UserID], ch)
+			close(ch)
+		}
+	}
+}
+
+// CloseStreams ends every event stream open on this instance, so a
+// shutting down server doesn't wait for them. Clients reconnect elsewhere
+// and replay what they missed.
+func (h *Hub) CloseStreams() {
+	h.closeAll()
+}
+
+func (h *Hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for userID, chans := range h.subs {
+		for ch := range chans {
+			close(ch)
+		}
+		delete(h.subs, userID)
+	}
+}
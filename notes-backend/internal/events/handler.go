@@ -0,0 +1,109 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const keepAliveInterval = 25 * time.Second
+
+// StreamHandler - GET /events
+// Server-Sent Events stream of the user's changes. Each event carries its
+// id, so a reconnecting client (Last-Event-ID header, or ?last_event_id=)
+// first gets everything it missed.
+func StreamHandler(h *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		lastID := int64(0)
+		raw := c.GetHeader("Last-Event-ID")
+		if raw == "" {
+			raw = c.Query("last_event_id")
+		}
+		if raw != "" {
+			id, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last-Event-ID"})
+				return
+			}
+			lastID = id
+		}
+
+		// Subscribe before replaying so nothing slips in between
+		ch := h.subscribe(userID)
+		defer h.unsubscribe(userID, ch)
+
+		var missed []Event
+		if lastID > 0 {
+			rows, err := h.db.Query(`
+				SELECT id, user_id, entity, entity_id, note_id, action, created_at
+				FROM events
+				WHERE user_id=$1 AND id > $2
+				ORDER BY id
+			`, userID, lastID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch events"})
+				return
+			}
+			for rows.Next() {
+				var ev Event
+				var createdAt time.Time
+				if err := rows.Scan(&ev.ID, &ev.UserID, &ev.Entity, &ev.EntityID, &ev.NoteID, &ev.Action, &createdAt); err != nil {
+					rows.Close()
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch events"})
+					return
+				}
+				ev.CreatedAt = createdAt.Format("2006-01-02T15:04:05.999999")
+				missed = append(missed, ev)
+			}
+			rows.Close()
+		}
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		fmt.Fprint(c.Writer, "retry: 3000\n\n")
+		c.Writer.Flush()
+
+		for _, ev := range missed {
+			writeEvent(c, ev)
+			lastID = ev.ID
+		}
+
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case ev, ok := <-ch:
+				if !ok {
+					// The hub dropped us; the client reconnects and replays
+					return
+				}
+				if ev.ID <= lastID {
+					continue
+				}
+				writeEvent(c, ev)
+				lastID = ev.ID
+			case <-keepAlive.C:
+				fmt.Fprint(c.Writer, ": keep-alive\n\n")
+				c.Writer.Flush()
+			}
+		}
+	}
+}
+
+func writeEvent(c *gin.Context, ev Event) {
+	data, _ := json.Marshal(ev)
+	fmt.Fprintf(c.Writer, "id: %d\nevent: %s.%s\ndata: %s\n\n", ev.ID, ev.Entity, ev.Action, data)
+	c.Writer.Flush()
+}
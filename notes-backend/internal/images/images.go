@@ -3,13 +3,28 @@ package images
 import (
 	"database/sql"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"notes-backend/internal/events"
+	"notes-backend/internal/sharing"
+
 	"github.com/gin-gonic/gin"
 )
 
+const baseURL = "http://localhost:8080/"
+
+// MaxImageSize is the largest image that is stored, whether uploaded or
+// imported
+const MaxImageSize = 10 << 20
+
+// ErrImageTooLarge is returned by StoreImage for images over MaxImageSize
+var ErrImageTooLarge = fmt.Errorf("image is larger than %d MB", MaxImageSize>>20)
+
 type Image struct {
 	ID        int    `json:"id"`
 	NoteID    int    `json:"note_id"`
@@ -23,66 +38,110 @@ func UploadImageHandler(db *sql.DB) gin.HandlerFunc {
 		userID := c.GetInt("userID")
 		noteID := c.Param("id")
 
-		// Check if note belongs to user
-		var exists bool
-		err := db.QueryRow(`
-			SELECT EXISTS(SELECT 1 FROM notes WHERE id=$1 AND user_id=$2 AND deleted_at IS NULL)
-		`, noteID, userID).Scan(&exists)
-
-		if err != nil || !exists {
+		// Owners and editors can change a note's images
+		role, err := sharing.NoteRole(db, noteID, userID)
+		if err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found or not owned by user"})
 			return
 		}
+		if !sharing.CanEdit(role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You only have view access to this note"})
+			return
+		}
 
+		// Leave room for the multipart framing around the file
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxImageSize+1<<20)
 		file, err := c.FormFile("image")
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Image file is required"})
 			return
 		}
-
-		// Save file locally (uploads folder)
-		filename := fmt.Sprintf("uploads/%d_%s", time.Now().Unix(), file.Filename)
-		if err := c.SaveUploadedFile(file, filename); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
+		if file.Size > MaxImageSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": ErrImageTooLarge.Error()})
 			return
 		}
 
-		baseURL := "http://localhost:8080/"
-		fileURL := baseURL + filename
-
-		// Insert into images table
-		var imageID int
-		err = db.QueryRow(`
-			INSERT INTO images (note_id, url, created_at)
-			VALUES ($1, $2, $3)
-			RETURNING id
-		`, noteID, fileURL, time.Now()).Scan(&imageID)
+		src, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Image file is required"})
+			return
+		}
+		defer src.Close()
 
+		imageID, _, err := StoreImage(db, noteID, file.Filename, src)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image metadata"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
 			return
 		}
 
 		c.JSON(http.StatusOK, gin.H{
 			"message":  "Image uploaded successfully",
 			"image_id": imageID,
-			"url":      fileURL,
+			"url":      URL(noteID, imageID),
 		})
 	}
 }
 
+// DBTX is satisfied by both *sql.DB and *sql.Tx
+type DBTX interface {
+	sharing.Queryer
+	events.Execer
+}
+
+// StoreImage saves an image under uploads/ and records it for the note.
+// Uploads and imports both go through here so files land in one place, and
+// none of them can be larger than MaxImageSize. The returned URL is where
+// the file is stored; clients load the image from URL(noteID, imageID).
+func StoreImage(q DBTX, noteID interface{}, name string, src io.Reader) (int, string, error) {
+	// Save file locally (uploads folder)
+	filename := fmt.Sprintf("uploads/%d_%s", time.Now().UnixNano(), filepath.Base(name))
+	dst, err := os.Create(filename)
+	if err != nil {
+		return 0, "", err
+	}
+	n, err := io.Copy(dst, io.LimitReader(src, MaxImageSize+1))
+	if err == nil && n > MaxImageSize {
+		err = ErrImageTooLarge
+	}
+	if err != nil {
+		dst.Close()
+		os.Remove(filename)
+		return 0, "", err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(filename)
+		return 0, "", err
+	}
+
+	fileURL := baseURL + filename
+
+	// Insert into images table
+	var imageID int
+	err = q.QueryRow(`
+		INSERT INTO images (note_id, url, created_at)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, noteID, fileURL, time.Now()).Scan(&imageID)
+	if err != nil {
+		os.Remove(filename)
+		return 0, "", err
+	}
+
+	if err := events.PublishNote(q, noteID, "image", imageID, events.Created); err != nil {
+		os.Remove(filename)
+		return 0, "", err
+	}
+
+	return imageID, fileURL, nil
+}
+
 func ListImagesHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.GetInt("userID")
 		noteID := c.Param("id")
 
-		// Check if note belongs to user
-		var exists bool
-		err := db.QueryRow(`
-			SELECT EXISTS(SELECT 1 FROM notes WHERE id=$1 AND user_id=$2 AND deleted_at IS NULL)
-		`, noteID, userID).Scan(&exists)
-
-		if err != nil || !exists {
+		// Anyone with access to the note can see its images
+		if _, err := sharing.NoteRole(db, noteID, userID); err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found or not owned by user"})
 			return
 		}
@@ -112,6 +171,7 @@ func ListImagesHandler(db *sql.DB) gin.HandlerFunc {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning image"})
 				return
 			}
+			img.URL = URL(noteID, img.ID)
 			images = append(images, img)
 		}
 
@@ -119,21 +179,49 @@ func ListImagesHandler(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
+// GetImageHandler - GET /notes/:id/images/:image_id
+// Anyone with access to the note can load its images; uploads/ itself is
+// not served.
+func GetImageHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+		noteID := c.Param("id")
+
+		if _, err := sharing.NoteRole(db, noteID, userID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+
+		var url string
+		err := db.QueryRow(`
+			SELECT url FROM images WHERE id=$1 AND note_id=$2
+		`, c.Param("image_id"), noteID).Scan(&url)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+
+		c.Header("Cache-Control", "private, max-age=3600")
+		c.File(LocalPath(url))
+	}
+}
+
 func DeleteImageHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.GetInt("userID")
 		noteID := c.Param("id")
 		imageID := c.Param("image_id")
 
-		// Check if note belongs to the user
-		var exists bool
-		err := db.QueryRow(`
-			SELECT EXISTS(SELECT 1 FROM notes WHERE id=$1 AND user_id=$2 AND deleted_at IS NULL)
-		`, noteID, userID).Scan(&exists)
-		if err != nil || !exists {
+		// Owners and editors can change a note's images
+		role, err := sharing.NoteRole(db, noteID, userID)
+		if err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found or not owned by user"})
 			return
 		}
+		if !sharing.CanEdit(role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You only have view access to this note"})
+			return
+		}
 
 		// Get image path from DB
 		var imagePath string
@@ -152,8 +240,10 @@ func DeleteImageHandler(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		events.LogError(events.PublishNote(db, noteID, "image", imageID, events.Deleted))
+
 		// Delete file from uploads folder
-		if err := os.Remove(imagePath); err != nil {
+		if err := os.Remove(LocalPath(imagePath)); err != nil {
 			// not fatal, but log it
 			c.JSON(http.StatusOK, gin.H{
 				"message": "Image record deleted, but file could not be removed",
@@ -164,3 +254,27 @@ func DeleteImageHandler(db *sql.DB) gin.HandlerFunc {
 		c.JSON(http.StatusOK, gin.H{"message": "Image deleted successfully"})
 	}
 }
+
+// URL is where clients load an image from. It is host-relative, so note
+// bodies don't depend on where the API runs; clients fetch it with their
+// Authorization header like any other route.
+func URL(noteID interface{}, imageID int) string {
+	return fmt.Sprintf("/notes/%v/images/%d", noteID, imageID)
+}
+
+// LocalPath maps a stored image URL back to its file under uploads/
+func LocalPath(url string) string {
+	return strings.TrimPrefix(url, baseURL)
+}
+
+// RemoveFiles deletes the upload files behind the given image URLs.
+// Missing files are ignored; the first other error is returned.
+func RemoveFiles(urls []string) error {
+	var firstErr error
+	for _, u := range urls {
+		if err := os.Remove(LocalPath(u)); err != nil && !os.IsNotExist(err) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,392 @@
+package importer
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+
+	"notes-backend/internal/images"
+)
+
+// enexTime is the timestamp layout Evernote uses in ENEX files
+const enexTime = "20060102T150405Z"
+
+type enexExport struct {
+	Notes []enexNote `xml:"note"`
+}
+
+type enexNote struct {
+	Title     string         `xml:"title"`
+	Content   string         `xml:"content"`
+	Created   string         `xml:"created"`
+	Updated   string         `xml:"updated"`
+	Tags      []string       `xml:"tag"`
+	Resources []enexResource `xml:"resource"`
+}
+
+type enexResource struct {
+	Data struct {
+		Encoding string `xml:"encoding,attr"`
+		Value    string `xml:",chardata"`
+	} `xml:"data"`
+	Mime     string `xml:"mime"`
+	FileName string `xml:"resource-attributes>file-name"`
+}
+
+// ParseENEX reads an Evernote export. Note content (ENML) is converted to
+// Markdown and embedded resources become images; <en-media> tags turn into
+// links to them.
+func ParseENEX(data []byte) ([]Note, error) {
+	var doc enexExport
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid ENEX file: %w", err)
+	}
+
+	notes := make([]Note, 0, len(doc.Notes))
+	for _, en := range doc.Notes {
+		n := Note{
+			Title:      titleOrDefault(en.Title),
+			Tags:       en.Tags,
+			Visibility: "private",
+		}
+		n.CreatedAt, _ = time.Parse(enexTime, strings.TrimSpace(en.Created))
+		n.UpdatedAt, _ = time.Parse(enexTime, strings.TrimSpace(en.Updated))
+
+		// en-media refers to resources by the MD5 of their content
+		media := map[string]enexMedia{}
+		for i, res := range en.Resources {
+			if res.Data.Encoding != "" && res.Data.Encoding != "base64" {
+				continue
+			}
+			name := res.FileName
+			if name == "" {
+				name = fmt.Sprintf("resource-%d%s", i+1, extensionFor(res.Mime))
+			}
+
+			encoded := stripSpace(res.Data.Value)
+			if size := base64.StdEncoding.DecodedLen(len(encoded)); size > images.MaxImageSize {
+				n.Resources = append(n.Resources, Resource{FileName: name, Size: int64(size)})
+				continue
+			}
+			raw, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				continue
+			}
+			sum := md5.Sum(raw)
+			hash := hex.EncodeToString(sum[:])
+
+			media[hash] = enexMedia{name: name, image: strings.HasPrefix(res.Mime, "image/")}
+			n.Resources = append(n.Resources, Resource{FileName: name, Ref: hash, Size: int64(len(raw)), Data: raw})
+		}
+
+		body, err := enmlToMarkdown(en.Content, media)
+		if err != nil {
+			return nil, fmt.Errorf("note %q: %w", n.Title, err)
+		}
+		n.Body = body
+		notes = append(notes, n)
+	}
+	return notes, nil
+}
+
+type enexMedia struct {
+	name  string
+	image bool
+}
+
+func stripSpace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\n' || r == '\r' || r == '\t' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func extensionFor(mime string) string {
+	switch mime {
+	case "image/png":
+		return ".png"
+	case "image/jpeg":
+		return ".jpg"
+	case "image/gif":
+		return ".gif"
+	case "application/pdf":
+		return ".pdf"
+	}
+	return ""
+}
+
+// enmlNode is a minimal DOM for ENML documents
+type enmlNode struct {
+	name     string // empty for text nodes
+	attrs    map[string]string
+	text     string
+	children []*enmlNode
+}
+
+func parseENML(content string) (*enmlNode, error) {
+	dec := xml.NewDecoder(strings.NewReader(content))
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	root := &enmlNode{name: "#root"}
+	stack := []*enmlNode{root}
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("invalid ENML: %w", err)
+		}
+
+		parent := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &enmlNode{name: strings.ToLower(t.Name.Local), attrs: map[string]string{}}
+			for _, a := range t.Attr {
+				n.attrs[strings.ToLower(a.Name.Local)] = a.Value
+			}
+			parent.children = append(parent.children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			parent.children = append(parent.children, &enmlNode{text: string(t)})
+		}
+	}
+	return root, nil
+}
+
+// enmlToMarkdown converts Evernote's XHTML dialect to Markdown. media maps
+// resource hashes to the files <en-media> refers to.
+func enmlToMarkdown(content string, media map[string]enexMedia) (string, error) {
+	root, err := parseENML(content)
+	if err != nil {
+		return "", err
+	}
+
+	c := enmlConverter{media: media}
+	md := c.children(root, false)
+
+	// Collapse the blank lines that nested blocks leave behind
+	lines := strings.Split(md, "\n")
+	var out []string
+	blank := 0
+	for _, line := range lines {
+		line = strings.TrimRight(line, " ")
+		if line == "" {
+			blank++
+			if blank > 1 {
+				continue
+			}
+		} else {
+			blank = 0
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n")), nil
+}
+
+type enmlConverter struct {
+	media map[string]enexMedia
+}
+
+func (c enmlConverter) children(n *enmlNode, pre bool) string {
+	var b strings.Builder
+	for _, child := range n.children {
+		b.WriteString(c.node(child, pre))
+	}
+	return b.String()
+}
+
+func (c enmlConverter) node(n *enmlNode, pre bool) string {
+	if n.name == "" {
+		if pre {
+			return n.text
+		}
+		return collapseSpace(n.text)
+	}
+
+	switch n.name {
+	case "div", "p", "section", "article", "center":
+		inner := strings.TrimSpace(c.children(n, pre))
+		if n.name == "p" {
+			return "\n\n" + inner + "\n\n"
+		}
+		return "\n" + inner + "\n"
+	case "br":
+		return "\n"
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level := int(n.name[1] - '0')
+		return "\n\n" + strings.Repeat("#", level) + " " + strings.TrimSpace(c.children(n, pre)) + "\n\n"
+	case "b", "strong":
+		return wrapInline("**", c.children(n, pre))
+	case "i", "em":
+		return wrapInline("_", c.children(n, pre))
+	case "s", "strike", "del":
+		return wrapInline("~~", c.children(n, pre))
+	case "code", "tt":
+		return wrapInline("`", c.children(n, pre))
+	case "pre":
+		return "\n\n```\n" + strings.Trim(c.children(n, true), "\n") + "\n```\n\n"
+	case "a":
+		text := strings.TrimSpace(c.children(n, pre))
+		href := n.attrs["href"]
+		if href == "" {
+			return text
+		}
+		if text == "" {
+			text = href
+		}
+		return "[" + text + "](" + href + ")"
+	case "img":
+		return "![" + n.attrs["alt"] + "](" + n.attrs["src"] + ")"
+	case "hr":
+		return "\n\n---\n\n"
+	case "blockquote":
+		inner := strings.TrimSpace(c.children(n, pre))
+		lines := strings.Split(inner, "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		return "\n\n" + strings.Join(lines, "\n") + "\n\n"
+	case "ul", "ol":
+		return "\n\n" + c.list(n) + "\n\n"
+	case "table":
+		return "\n\n" + c.table(n) + "\n\n"
+	case "en-todo":
+		if n.attrs["checked"] == "true" {
+			return "[x] "
+		}
+		return "[ ] "
+	case "en-media":
+		m, ok := c.media[n.attrs["hash"]]
+		if !ok {
+			return ""
+		}
+		link := "[" + m.name + "](" + ResourceURL(n.attrs["hash"]) + ")"
+		if m.image {
+			return "!" + link
+		}
+		return link
+	case "en-crypt":
+		return "[encrypted content]"
+	case "style", "script", "title", "head":
+		return ""
+	}
+	return c.children(n, pre)
+}
+
+func (c enmlConverter) list(n *enmlNode) string {
+	var items []string
+	i := 0
+	for _, child := range n.children {
+		if child.name != "li" {
+			continue
+		}
+		i++
+		marker := "- "
+		if n.name == "ol" {
+			marker = fmt.Sprintf("%d. ", i)
+		}
+		inner := strings.TrimSpace(c.children(child, false))
+		// Keep nested lists tight against their parent item
+		for strings.Contains(inner, "\n\n") {
+			inner = strings.ReplaceAll(inner, "\n\n", "\n")
+		}
+		// Indent continuation lines (and nested lists) under the marker
+		lines := strings.Split(inner, "\n")
+		for j := 1; j < len(lines); j++ {
+			if lines[j] != "" {
+				lines[j] = strings.Repeat(" ", len(marker)) + lines[j]
+			}
+		}
+		items = append(items, marker+strings.Join(lines, "\n"))
+	}
+	return strings.Join(items, "\n")
+}
+
+func (c enmlConverter) table(n *enmlNode) string {
+	var rows [][]string
+	var collect func(*enmlNode)
+	collect = func(node *enmlNode) {
+		for _, child := range node.children {
+			switch child.name {
+			case "tr":
+				var cells []string
+				for _, cell := range child.children {
+					if cell.name == "td" || cell.name == "th" {
+						text := strings.TrimSpace(c.children(cell, false))
+						cells = append(cells, strings.ReplaceAll(strings.ReplaceAll(text, "\n", " "), "|", "\\|"))
+					}
+				}
+				rows = append(rows, cells)
+			case "thead", "tbody", "tfoot":
+				collect(child)
+			}
+		}
+	}
+	collect(n)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	var lines []string
+	for i, cells := range rows {
+		lines = append(lines, "| "+strings.Join(cells, " | ")+" |")
+		if i == 0 {
+			sep := make([]string, len(cells))
+			for j := range sep {
+				sep[j] = "---"
+			}
+			lines = append(lines, "| "+strings.Join(sep, " | ")+" |")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// wrapInline wraps text in a Markdown marker, keeping surrounding spaces
+// outside of it so "**bold **" doesn't happen.
+func wrapInline(marker, text string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	lead := text[:strings.Index(text, trimmed)]
+	trail := text[len(lead)+len(trimmed):]
+	return lead + marker + trimmed + marker + trail
+}
+
+// collapseSpace folds whitespace runs the way a browser would, keeping a
+// single space at either end so words around inline tags stay apart.
+func collapseSpace(s string) string {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		if s != "" {
+			return " "
+		}
+		return ""
+	}
+	out := strings.Join(fields, " ")
+	if unicode.IsSpace(rune(s[0])) {
+		out = " " + out
+	}
+	if unicode.IsSpace(rune(s[len(s)-1])) {
+		out += " "
+	}
+	return out
+}
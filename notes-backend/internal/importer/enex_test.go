@@ -0,0 +1,138 @@
+package importer
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func enml(body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note>` + body + `</en-note>`
+}
+
+func TestENMLToMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		enml string
+		want string
+	}{
+		{"paragraphs", `<div>First line</div><div>Second   line</div>`, "First line\n\nSecond line"},
+		{"line break", `<div>one<br/>two</div>`, "one\ntwo"},
+		{"heading", `<h2>Plan</h2><p>text</p>`, "## Plan\n\ntext"},
+		{"inline styles", `<div>a <b>bold </b><i>it</i> <s>gone</s> <code>x()</code></div>`, "a **bold** _it_ ~~gone~~ `x()`"},
+		{"link", `<div><a href="https://example.com">site</a> <a href="https://example.com/x"></a></div>`, "[site](https://example.com) [https://example.com/x](https://example.com/x)"},
+		{"unordered list", `<ul><li>a</li><li>b</li></ul>`, "- a\n- b"},
+		{"ordered nested list", `<ol><li>a<ul><li>b</li></ul></li><li>c</li></ol>`, "1. a\n   - b\n2. c"},
+		{"checkboxes", `<div><en-todo checked="true"/>done</div><div><en-todo/>todo</div>`, "[x] done\n\n[ ] todo"},
+		{"quote", `<blockquote><div>one</div><div>two</div></blockquote>`, "> one\n>\n> two"},
+		{"code block", "<pre>if x {\n  y()\n}</pre>", "```\nif x {\n  y()\n}\n```"},
+		{"table", `<table><tr><th>k</th><th>v</th></tr><tr><td>a|b</td><td>1</td></tr></table>`, "| k | v |\n| --- | --- |\n| a\\|b | 1 |"},
+		{"rule", `<div>a</div><hr/><div>b</div>`, "a\n\n---\n\nb"},
+		{"entities", `<div>Tom &amp; Jerry &lt;3 &quot;x&quot;</div>`, `Tom & Jerry <3 "x"`},
+		{"encrypted", `<div><en-crypt cipher="AES">abc</en-crypt></div>`, "[encrypted content]"},
+		{"dropped elements", `<style>b{}</style><div>kept</div>`, "kept"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := enmlToMarkdown(enml(tt.enml), nil)
+			if err != nil {
+				t.Fatalf("enmlToMarkdown: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestENMLMedia(t *testing.T) {
+	media := map[string]enexMedia{
+		"aaa": {name: "photo.png", image: true},
+		"bbb": {name: "doc.pdf"},
+	}
+	got, err := enmlToMarkdown(enml(`<div><en-media hash="aaa" type="image/png"/></div><div><en-media hash="bbb" type="application/pdf"/></div><div><en-media hash="ccc"/>x</div>`), media)
+	if err != nil {
+		t.Fatalf("enmlToMarkdown: %v", err)
+	}
+	want := "![photo.png](resource:aaa)\n\n[doc.pdf](resource:bbb)\n\nx"
+	if got != want {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestParseENEX(t *testing.T) {
+	image := []byte("\x89PNG fake image")
+	sum := md5.Sum(image)
+	hash := hex.EncodeToString(sum[:])
+	// ENEX wraps base64 data over several lines
+	encoded := base64.StdEncoding.EncodeToString(image)
+	encoded = encoded[:8] + "\n  " + encoded[8:]
+
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<en-export>
+<note>
+	<title>Trip</title>
+	<content><![CDATA[` + enml(`<div>Look:</div><en-media hash="`+hash+`" type="image/png"/>`) + `]]></content>
+	<created>20240102T030405Z</created>
+	<updated>20240203T040506Z</updated>
+	<tag>travel</tag>
+	<tag>2024</tag>
+	<resource>
+		<data encoding="base64">` + encoded + `</data>
+		<mime>image/png</mime>
+		<resource-attributes><file-name>beach.png</file-name></resource-attributes>
+	</resource>
+</note>
+<note>
+	<title>  </title>
+	<content><![CDATA[` + enml(`<div>untitled</div>`) + `]]></content>
+</note>
+</en-export>`
+
+	notes, err := ParseENEX([]byte(doc))
+	if err != nil {
+		t.Fatalf("ParseENEX: %v", err)
+	}
+	if len(notes) != 2 {
+		t.Fatalf("got %d notes, want 2", len(notes))
+	}
+
+	n := notes[0]
+	if n.Title != "Trip" || n.Visibility != "private" {
+		t.Errorf("title %q, visibility %q", n.Title, n.Visibility)
+	}
+	if strings.Join(n.Tags, ",") != "travel,2024" {
+		t.Errorf("tags = %v", n.Tags)
+	}
+	if want := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC); !n.CreatedAt.Equal(want) {
+		t.Errorf("created = %v, want %v", n.CreatedAt, want)
+	}
+	if want := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC); !n.UpdatedAt.Equal(want) {
+		t.Errorf("updated = %v, want %v", n.UpdatedAt, want)
+	}
+	if want := "Look:\n![beach.png](" + ResourceURL(hash) + ")"; n.Body != want {
+		t.Errorf("body = %q, want %q", n.Body, want)
+	}
+	if len(n.Resources) != 1 {
+		t.Fatalf("got %d resources, want 1", len(n.Resources))
+	}
+	r := n.Resources[0]
+	if r.FileName != "beach.png" || r.Ref != hash || r.Size != int64(len(image)) || string(r.Data) != string(image) {
+		t.Errorf("resource = %+v", r)
+	}
+
+	if notes[1].Title != titleOrDefault("") {
+		t.Errorf("blank title became %q", notes[1].Title)
+	}
+}
+
+func TestParseENEXInvalid(t *testing.T) {
+	if _, err := ParseENEX([]byte("not xml at all <")); err == nil {
+		t.Fatal("ParseENEX accepted garbage")
+	}
+}
@@ -0,0 +1,90 @@
+package importer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Note is a note read from an import file, before it is stored
+type Note struct {
+	Title      string
+	Body       string // Markdown
+	Category   string
+	Favorite   bool
+	Visibility string
+	Tags       []string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Resources  []Resource
+}
+
+// Resource is a file embedded in an imported note. If the body refers to
+// it as "resource:<Ref>", the reference is replaced by the stored image URL.
+// Data is left out of resources over images.MaxImageSize, and Size makes
+// the note fail on them.
+type Resource struct {
+	FileName string
+	Ref      string
+	Size     int64
+	Data     []byte
+}
+
+// ResourceURL is the placeholder a parser puts in a body for a resource
+func ResourceURL(ref string) string {
+	return "resource:" + ref
+}
+
+// Parser turns an uploaded file into notes
+type Parser func(data []byte) ([]Note, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Parser{}
+)
+
+// Register makes a format available to POST /imports?format=<name>
+func Register(name string, parser Parser) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[name]; dup {
+		panic(fmt.Sprintf("importer: format %q registered twice", name))
+	}
+	registry[name] = parser
+}
+
+// Lookup returns the parser registered for a format
+func Lookup(name string) (Parser, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	parser, ok := registry[name]
+	return parser, ok
+}
+
+// Formats lists the registered format names
+func Formats() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	Register("enex", ParseENEX)
+	Register("json", ParseJSON)
+}
+
+// titleOrDefault keeps imported notes valid when the source has no title
+func titleOrDefault(title string) string {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return "Untitled"
+	}
+	return title
+}
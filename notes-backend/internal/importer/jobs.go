@@ -0,0 +1,378 @@
+package importer
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"notes-backend/internal/notes"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	maxUploadSize = 100 << 20
+	// Uploads wait here until their job runs, instead of in memory
+	spoolDir = "imports"
+
+	// Unfinished imports allowed at once, across all instances
+	maxActivePerUser = 3
+	maxActiveTotal   = 50
+
+	// Running and queued jobs are touched every heartbeatInterval; jobs
+	// nobody touched for staleAfter were lost with their instance
+	heartbeatInterval = time.Minute
+	staleAfter        = 5 * time.Minute
+)
+
+// lockClass and lockStart name the advisory lock that serializes counting
+// active jobs with starting a new one
+const (
+	lockClass = 1003
+	lockStart = 1
+)
+
+var errTooManyImports = errors.New("too many imports in progress")
+
+// jobSlots bounds how many imports run at the same time on this instance
+var jobSlots = make(chan struct{}, 2)
+
+// active holds the IDs of the jobs queued or running on this instance
+var active = struct {
+	sync.Mutex
+	ids map[int]bool
+}{ids: map[int]bool{}}
+
+// ItemError is a note that could not be imported
+type ItemError struct {
+	Index int    `json:"index"`
+	Title string `json:"title"`
+	Error string `json:"error"`
+}
+
+type Job struct {
+	ID         int         `json:"id"`
+	Format     string      `json:"format"`
+	Status     string      `json:"status"` // "queued", "running", "done", "failed"
+	Total      int         `json:"total"`
+	Processed  int         `json:"processed"`
+	Imported   int         `json:"imported"`
+	Errors     []ItemError `json:"errors"`
+	Error      *string     `json:"error,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+}
+
+// StartImportHandler - POST /imports?format=enex
+// Takes the file in form field "file" and imports it in the background.
+// Poll GET /imports/:id for progress. A user can have maxActivePerUser
+// imports queued or running at a time.
+func StartImportHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+		format := c.Query("format")
+
+		parser, ok := Lookup(format)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown format, use one of: " + strings.Join(Formats(), ", ")})
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadSize)
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Import file is required"})
+			return
+		}
+		src, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Import file is required"})
+			return
+		}
+		path, err := spool(src)
+		src.Close()
+		if err != nil {
+			fmt.Printf("❌ Failed to store import file: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store import file"})
+			return
+		}
+
+		jobID, err := createJob(db, userID, format, path)
+		if err != nil {
+			os.Remove(path)
+		}
+		if err == errTooManyImports {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many imports in progress, try again when one has finished"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start import"})
+			return
+		}
+
+		go runJob(db, jobID, userID, parser, path)
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "Import started", "id": jobID})
+	}
+}
+
+// GetImportHandler - GET /imports/:id
+func GetImportHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+		jobID := c.Param("id")
+
+		var j Job
+		var errs []byte
+		var jobErr sql.NullString
+		var finishedAt sql.NullTime
+		err := db.QueryRow(`
+			SELECT id, format, status, total, processed, imported, errors, error, created_at, finished_at
+			FROM import_jobs
+			WHERE id=$1 AND user_id=$2
+		`, jobID, userID).Scan(&j.ID, &j.Format, &j.Status, &j.Total, &j.Processed, &j.Imported,
+			&errs, &jobErr, &j.CreatedAt, &finishedAt)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch import"})
+			return
+		}
+
+		j.Errors = []ItemError{}
+		if len(errs) > 0 {
+			json.Unmarshal(errs, &j.Errors)
+		}
+		if jobErr.Valid {
+			j.Error = &jobErr.String
+		}
+		if finishedAt.Valid {
+			j.FinishedAt = &finishedAt.Time
+		}
+
+		c.JSON(http.StatusOK, j)
+	}
+}
+
+// spool copies an upload to a file in spoolDir
+func spool(src io.Reader) (string, error) {
+	if err := os.MkdirAll(spoolDir, 0700); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(spoolDir, "upload-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// createJob queues a job for this instance, unless the user or everyone
+// together already has too many
+func createJob(db *sql.DB, userID int, format, path string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1, $2)`, lockClass, lockStart); err != nil {
+		return 0, err
+	}
+	var mine, all int
+	if err := tx.QueryRow(`
+		SELECT COUNT(*) FILTER (WHERE user_id=$1), COUNT(*)
+		FROM import_jobs WHERE status IN ('queued', 'running')
+	`, userID).Scan(&mine, &all); err != nil {
+		return 0, err
+	}
+	if mine >= maxActivePerUser || all >= maxActiveTotal {
+		return 0, errTooManyImports
+	}
+
+	var jobID int
+	if err := tx.QueryRow(`
+		INSERT INTO import_jobs (user_id, format, status, upload_path, heartbeat_at)
+		VALUES ($1, $2, 'queued', $3, NOW())
+		RETURNING id
+	`, userID, format, path).Scan(&jobID); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	active.Lock()
+	active.ids[jobID] = true
+	active.Unlock()
+	return jobID, nil
+}
+
+func runJob(db *sql.DB, jobID, userID int, parser Parser, path string) {
+	defer func() {
+		active.Lock()
+		delete(active.ids, jobID)
+		active.Unlock()
+	}()
+	defer os.Remove(path)
+
+	jobSlots <- struct{}{}
+	defer func() { <-jobSlots }()
+
+	defer func() {
+		if r := recover(); r != nil {
+			failJob(db, jobID, fmt.Sprintf("import crashed: %v", r))
+		}
+	}()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		failJob(db, jobID, "failed to read import file")
+		return
+	}
+	parsed, err := parser(data)
+	if err != nil {
+		failJob(db, jobID, err.Error())
+		return
+	}
+
+	if _, err := db.Exec(`
+		UPDATE import_jobs SET status='running', total=$1 WHERE id=$2
+	`, len(parsed), jobID); err != nil {
+		fmt.Printf("❌ Failed to update import job %d: %v\n", jobID, err)
+	}
+
+	imported := 0
+	errs := []ItemError{}
+	for i, n := range parsed {
+		if err := importNote(db, userID, n); err != nil {
+			errs = append(errs, ItemError{Index: i, Title: n.Title, Error: err.Error()})
+		} else {
+			imported++
+		}
+
+		errJSON, _ := json.Marshal(errs)
+		if _, err := db.Exec(`
+			UPDATE import_jobs SET processed=$1, imported=$2, errors=$3 WHERE id=$4
+		`, i+1, imported, errJSON, jobID); err != nil {
+			fmt.Printf("❌ Failed to update import job %d: %v\n", jobID, err)
+		}
+	}
+
+	if _, err := db.Exec(`
+		UPDATE import_jobs SET status='done', finished_at=$1 WHERE id=$2
+	`, time.Now(), jobID); err != nil {
+		fmt.Printf("❌ Failed to finish import job %d: %v\n", jobID, err)
+	}
+}
+
+// StartJobSweeper keeps this instance's jobs alive and fails the ones
+// whose instance went away, e.g. in a restart, so they don't look like they
+// are still making progress. Their uploads are removed if they are here.
+func StartJobSweeper(db *sql.DB) {
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := sweepJobs(db); err != nil {
+				fmt.Printf("❌ Failed to sweep import jobs: %v\n", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+func sweepJobs(db *sql.DB) error {
+	active.Lock()
+	ids := make([]int64, 0, len(active.ids))
+	for id := range active.ids {
+		ids = append(ids, int64(id))
+	}
+	active.Unlock()
+
+	if len(ids) > 0 {
+		if _, err := db.Exec(`
+			UPDATE import_jobs SET heartbeat_at=NOW() WHERE id = ANY($1)
+		`, pq.Array(ids)); err != nil {
+			return err
+		}
+	}
+
+	rows, err := db.Query(`
+		UPDATE import_jobs
+		SET status='failed', error='The import was interrupted, please start it again', finished_at=NOW()
+		WHERE status IN ('queued', 'running') AND heartbeat_at < $1
+		RETURNING upload_path
+	`, time.Now().Add(-staleAfter))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var path sql.NullString
+		if err := rows.Scan(&path); err != nil {
+			return err
+		}
+		if path.Valid {
+			os.Remove(path.String)
+		}
+	}
+	return rows.Err()
+}
+
+func failJob(db *sql.DB, jobID int, reason string) {
+	if _, err := db.Exec(`
+		UPDATE import_jobs SET status='failed', error=$1, finished_at=$2 WHERE id=$3
+	`, reason, time.Now(), jobID); err != nil {
+		fmt.Printf("❌ Failed to update import job %d: %v\n", jobID, err)
+	}
+}
+
+// importNote stores one parsed note with its category, tags and images
+func importNote(db *sql.DB, userID int, n Note) error {
+	in := notes.ImportedNote{
+		Request: notes.NoteRequest{
+			Title:      titleOrDefault(n.Title),
+			Body:       n.Body,
+			IsFavorite: n.Favorite,
+			Visibility: n.Visibility,
+			Tags:       n.Tags,
+		},
+		Category:  n.Category,
+		CreatedAt: n.CreatedAt,
+		UpdatedAt: n.UpdatedAt,
+	}
+	for _, res := range n.Resources {
+		data := res.Data
+		img := notes.ImportedImage{
+			Name: res.FileName,
+			Size: res.Size,
+			Open: func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil },
+		}
+		if res.Ref != "" {
+			img.Placeholder = ResourceURL(res.Ref)
+		}
+		in.Images = append(in.Images, img)
+	}
+
+	_, err := notes.ImportNote(db, userID, in)
+	return err
+}
@@ -0,0 +1,86 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// JSON import format (format=json):
+//
+//	{
+//	  "notes": [
+//	    {
+//	      "title": "Groceries",                  // required
+//	      "body": "- milk\n- eggs",              // Markdown
+//	      "category": "Home",                    // created if missing
+//	      "favorite": false,
+//	      "visibility": "private",               // "private" or "public"
+//	      "tags": ["errands"],                   // created if missing
+//	      "created_at": "2024-01-02T15:04:05Z",  // RFC 3339, defaults to now
+//	      "updated_at": "2024-01-03T09:00:00Z",  // RFC 3339, defaults to created_at
+//	      "images": [
+//	        {"file_name": "list.png", "data": "<base64>"}
+//	      ]
+//	    }
+//	  ]
+//	}
+//
+// An image with an "id" can be referenced from the body as
+// ![alt](resource:<id>); the link is rewritten to the stored image URL.
+type jsonExport struct {
+	Notes []jsonNote `json:"notes"`
+}
+
+type jsonNote struct {
+	Title      string      `json:"title"`
+	Body       string      `json:"body"`
+	Category   string      `json:"category"`
+	Favorite   bool        `json:"favorite"`
+	Visibility string      `json:"visibility"`
+	Tags       []string    `json:"tags"`
+	CreatedAt  *time.Time  `json:"created_at"`
+	UpdatedAt  *time.Time  `json:"updated_at"`
+	Images     []jsonImage `json:"images"`
+}
+
+type jsonImage struct {
+	ID       string `json:"id"`
+	FileName string `json:"file_name"`
+	Data     []byte `json:"data"` // base64 in the document
+}
+
+// ParseJSON reads the JSON import format documented above
+func ParseJSON(data []byte) ([]Note, error) {
+	var doc jsonExport
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	notes := make([]Note, 0, len(doc.Notes))
+	for _, jn := range doc.Notes {
+		n := Note{
+			Title:      titleOrDefault(jn.Title),
+			Body:       jn.Body,
+			Category:   jn.Category,
+			Favorite:   jn.Favorite,
+			Visibility: jn.Visibility,
+			Tags:       jn.Tags,
+		}
+		if jn.CreatedAt != nil {
+			n.CreatedAt = *jn.CreatedAt
+		}
+		if jn.UpdatedAt != nil {
+			n.UpdatedAt = *jn.UpdatedAt
+		}
+		for i, img := range jn.Images {
+			name := img.FileName
+			if name == "" {
+				name = fmt.Sprintf("image-%d", i+1)
+			}
+			n.Resources = append(n.Resources, Resource{FileName: name, Ref: img.ID, Size: int64(len(img.Data)), Data: img.Data})
+		}
+		notes = append(notes, n)
+	}
+	return notes, nil
+}
@@ -2,61 +2,247 @@ package logs
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 )
 
+const (
+	defaultLimit = 50
+	maxLimit     = 200
+)
+
+// Summary is a log entry as listed; bodies are only in the detail view
+type Summary struct {
+	ID         int64     `json:"id"`
+	Method     string    `json:"method"`
+	Endpoint   string    `json:"endpoint"`
+	StatusCode int       `json:"status_code"`
+	UserID     *int      `json:"user_id"`
+	RequestID  *string   `json:"request_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Entry is a full log entry
+type Entry struct {
+	Summary
+	RequestHeaders json.RawMessage `json:"request_headers"`
+	RequestBody    json.RawMessage `json:"request_body"`
+	ResponseBody   json.RawMessage `json:"response_body"`
+}
+
+// cursor is the position after the last listed entry. Entries are ordered
+// by (created_at, id), newest first.
+type cursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        int64     `json:"id"`
+}
+
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
+
+// filter builds the WHERE clause of a listing from the query string
+type filter struct {
+	where []string
+	args  []interface{}
+}
+
+func (f *filter) add(cond string, arg interface{}) {
+	f.args = append(f.args, arg)
+	f.where = append(f.where, strings.ReplaceAll(cond, "?", fmt.Sprintf("$%d", len(f.args))))
+}
+
+func (f *filter) sql() string {
+	if len(f.where) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(f.where, " AND ")
+}
+
+// likePrefix escapes LIKE wildcards so the prefix matches literally
+func likePrefix(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s) + "%"
+}
+
+// parseFilter reads the explorer's filters:
+//
+//	method      GET, or several as GET,POST
+//	endpoint    path prefix, e.g. /notes
+//	status_min  lowest status code, e.g. 500
+//	status_max  highest status code, e.g. 599
+//	user_id     user who made the request
+//	from, to    time window, RFC 3339
+//	request_id  X-Request-ID of the request
+func parseFilter(c *gin.Context) (*filter, error) {
+	f := &filter{}
+
+	if v := c.Query("method"); v != "" {
+		var methods []string
+		for _, m := range strings.Split(v, ",") {
+			methods = append(methods, strings.ToUpper(strings.TrimSpace(m)))
+		}
+		f.add("method = ANY(?)", pq.Array(methods))
+	}
+	if v := c.Query("endpoint"); v != "" {
+		f.add(`endpoint LIKE ? ESCAPE '\'`, likePrefix(v))
+	}
+	for _, p := range []struct{ param, cond string }{
+		{"status_min", "status_code >= ?"},
+		{"status_max", "status_code <= ?"},
+		{"user_id", "user_id = ?"},
+	} {
+		if v := c.Query(p.param); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a number", p.param)
+			}
+			f.add(p.cond, n)
+		}
+	}
+	for _, p := range []struct{ param, cond string }{
+		{"from", "created_at >= ?"},
+		{"to", "created_at < ?"},
+	} {
+		if v := c.Query(p.param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an RFC 3339 time", p.param)
+			}
+			// created_at has no zone and holds UTC, so a bound with an
+			// offset has to be moved to UTC before the zone is dropped
+			f.add(p.cond, t.UTC())
+		}
+	}
+	if v := c.Query("request_id"); v != "" {
+		f.add("request_id = ?", v)
+	}
+	return f, nil
+}
+
+// ListLogsHandler - GET /logs
+// Filters are described at parseFilter. Pages are limit entries long
+// (default 50, at most 200); pass next_cursor back as cursor for the next.
 func ListLogsHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		f, err := parseFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		limit := defaultLimit
+		if v := c.Query("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+				return
+			}
+			limit = min(n, maxLimit)
+		}
+		if v := c.Query("cursor"); v != "" {
+			cur, err := decodeCursor(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+				return
+			}
+			// The plain created_at bound lets Postgres skip newer partitions;
+			// it can't do that from the row comparison alone
+			f.args = append(f.args, cur.CreatedAt, cur.ID)
+			f.where = append(f.where, fmt.Sprintf("created_at <= $%d AND (created_at, id) < ($%d, $%d)", len(f.args)-1, len(f.args)-1, len(f.args)))
+		}
+
+		f.args = append(f.args, limit+1)
 		rows, err := db.Query(`
-			SELECT id, method, endpoint, request_headers, request_body, response_body, status_code, created_at
+			SELECT id, method, endpoint, status_code, user_id, request_id, created_at
 			FROM logs
-			ORDER BY created_at DESC
-			LIMIT 50
-		`)
+			`+f.sql()+`
+			ORDER BY created_at DESC, id DESC
+			LIMIT $`+strconv.Itoa(len(f.args)), f.args...)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch logs"})
 			return
 		}
 		defer rows.Close()
 
-		type Log struct {
-			ID             int64       `json:"id"`
-			Method         string      `json:"method"`
-			Endpoint       string      `json:"endpoint"`
-			RequestHeaders interface{} `json:"request_headers"`
-			RequestBody    interface{} `json:"request_body"`
-			ResponseBody   interface{} `json:"response_body"`
-			StatusCode     int         `json:"status_code"`
-			CreatedAt      string      `json:"created_at"`
-		}
-
-		var logs []Log
+		logs := []Summary{}
 		for rows.Next() {
-			var l Log
-			var reqHeaders, reqBody, resBody []byte
-			if err := rows.Scan(
-				&l.ID, &l.Method, &l.Endpoint,
-				&reqHeaders, &reqBody, &resBody,
-				&l.StatusCode, &l.CreatedAt,
-			); err != nil {
+			var l Summary
+			if err := rows.Scan(&l.ID, &l.Method, &l.Endpoint, &l.StatusCode, &l.UserID, &l.RequestID, &l.CreatedAt); err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to parse log"})
 				return
 			}
-			l.RequestHeaders = jsonBytesToInterface(reqHeaders)
-			l.RequestBody = jsonBytesToInterface(reqBody)
-			l.ResponseBody = jsonBytesToInterface(resBody)
 			logs = append(logs, l)
 		}
 
-		c.JSON(http.StatusOK, logs)
+		var next *string
+		if len(logs) > limit {
+			logs = logs[:limit]
+			last := logs[limit-1]
+			s := cursor{CreatedAt: last.CreatedAt, ID: last.ID}.encode()
+			next = &s
+		}
+
+		c.JSON(http.StatusOK, gin.H{"logs": logs, "next_cursor": next})
 	}
 }
 
-func jsonBytesToInterface(b []byte) interface{} {
-	if len(b) == 0 || string(b) == "null" {
-		return nil
+// GetLogHandler - GET /logs/:id
+func GetLogHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var l Entry
+		var reqHeaders, reqBody, resBody []byte
+		err := db.QueryRow(`
+			SELECT id, method, endpoint, status_code, user_id, request_id, created_at,
+				request_headers, request_body, response_body
+			FROM logs WHERE id=$1
+		`, c.Param("id")).Scan(&l.ID, &l.Method, &l.Endpoint, &l.StatusCode, &l.UserID, &l.RequestID, &l.CreatedAt,
+			&reqHeaders, &reqBody, &resBody)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "log not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch log"})
+			return
+		}
+		l.RequestHeaders = rawJSON(reqHeaders)
+		l.RequestBody = rawJSON(reqBody)
+		l.ResponseBody = rawJSON(resBody)
+
+		c.JSON(http.StatusOK, l)
+	}
+}
+
+// rawJSON passes a JSONB column through as is
+func rawJSON(b []byte) json.RawMessage {
+	if len(b) == 0 {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(b)
+}
+
+// StatsHandler - GET /logs/stats
+// Shows whether the log writer keeps up.
+func StatsHandler(w *Writer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, w.Stats())
 	}
-	return string(b) // return as string for simplicity
 }
@@ -0,0 +1,46 @@
+package logs
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func filterFor(t *testing.T, query string) (*filter, error) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/logs?"+query, nil)
+	return parseFilter(c)
+}
+
+func TestParseFilterTimesInUTC(t *testing.T) {
+	f, err := filterFor(t, "from=2024-03-01T09:30:00%2B02:00&to=2024-03-02T00:00:00Z")
+	if err != nil {
+		t.Fatalf("parseFilter: %v", err)
+	}
+	if got := f.sql(); got != "WHERE created_at >= $1 AND created_at < $2" {
+		t.Errorf("sql = %q", got)
+	}
+	want := []time.Time{
+		time.Date(2024, 3, 1, 7, 30, 0, 0, time.UTC),
+		time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC),
+	}
+	for i, w := range want {
+		got, ok := f.args[i].(time.Time)
+		// The wall clock is what reaches the TIMESTAMP column
+		if !ok || got.Location() != time.UTC || !got.Equal(w) {
+			t.Errorf("arg %d = %v, want %v", i, f.args[i], w)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, q := range []string{"from=yesterday", "to=2024-03-01", "status_min=abc"} {
+		if _, err := filterFor(t, q); err == nil {
+			t.Errorf("accepted %q", q)
+		}
+	}
+}
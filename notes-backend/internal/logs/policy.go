@@ -0,0 +1,312 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// What the logs keep of requests and responses is set by a policy file
+// named by LOG_POLICY_FILE, in the format of defaultPolicy below. It
+// replaces the default entirely.
+//
+// mask_fields are JSON paths whose values are replaced with "*****". A bare
+// name like "password" matches that field at any depth, "user.password"
+// matches it under a "user" object at any depth, and a leading "$." anchors
+// the path at the root. "*" matches any one field name, and arrays are
+// looked through, so "$.notes.*" masks every field of every note in a list.
+// Form bodies are masked by field name too.
+//
+// Routes are matched against the gin route pattern (/notes/:id), or a
+// prefix when the path ends in "*". Every matching rule applies in order,
+// later ones overriding earlier ones, and their mask_fields add up.
+const defaultPolicy = `
+max_body_bytes: 16384
+capture_content_types:
+  - application/json
+  - application/x-www-form-urlencoded
+  - text/plain
+mask_headers: [Authorization, Cookie, X-Api-Key]
+mask_fields:
+  - password
+  - current_password
+  - new_password
+  - token
+  - refresh_token
+  - challenge_token
+  - code
+  - recovery_code
+  - recovery_codes
+  - secret
+  - otpauth_uri
+  - client_secret
+  - export_token
+routes:
+  # Note contents are private; keep the request shape but not the text
+  - path: /notes*
+    mask_fields: [body, title]
+  - path: /sync
+    mask_fields: [body, title]
+  - path: /p/*
+    response_body: false
+  # Log entries are already in the logs
+  - path: /logs*
+    response_body: false
+  - path: /health
+    skip: true
+`
+
+const masked = "*****"
+
+// Policy decides what is captured of each request
+type Policy struct {
+	MaxBodyBytes int         `yaml:"max_body_bytes"`
+	ContentTypes []string    `yaml:"capture_content_types"`
+	MaskHeaders  []string    `yaml:"mask_headers"`
+	MaskFields   []string    `yaml:"mask_fields"`
+	Routes       []RouteRule `yaml:"routes"`
+
+	maskHeaders map[string]bool
+}
+
+// RouteRule adjusts the policy for some routes
+type RouteRule struct {
+	Method       string   `yaml:"method"` // empty for any
+	Path         string   `yaml:"path"`
+	Skip         bool     `yaml:"skip"` // don't log these requests at all
+	RequestBody  *bool    `yaml:"request_body"`
+	ResponseBody *bool    `yaml:"response_body"`
+	MaskFields   []string `yaml:"mask_fields"`
+}
+
+// LoadPolicy reads LOG_POLICY_FILE, or returns the default policy
+func LoadPolicy() (*Policy, error) {
+	data := []byte(defaultPolicy)
+	if path := os.Getenv("LOG_POLICY_FILE"); path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("reading LOG_POLICY_FILE: %w", err)
+		}
+	}
+	return ParsePolicy(data)
+}
+
+func ParsePolicy(data []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("parsing log policy: %w", err)
+	}
+	if p.MaxBodyBytes <= 0 {
+		return nil, fmt.Errorf("log policy: max_body_bytes must be positive")
+	}
+	for _, r := range p.Routes {
+		if r.Path == "" {
+			return nil, fmt.Errorf("log policy: every route needs a path")
+		}
+	}
+	p.maskHeaders = map[string]bool{}
+	for _, h := range p.MaskHeaders {
+		p.maskHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+	return p, nil
+}
+
+// RoutePolicy is the policy for one route
+type RoutePolicy struct {
+	Skip            bool
+	CaptureRequest  bool
+	CaptureResponse bool
+	MaxBodyBytes    int
+
+	policy *Policy
+	masks  [][]string
+}
+
+// For resolves the rules for a request; route is gin's FullPath, which is
+// empty for unmatched requests
+func (p *Policy) For(method, route string) RoutePolicy {
+	rp := RoutePolicy{CaptureRequest: true, CaptureResponse: true, MaxBodyBytes: p.MaxBodyBytes, policy: p}
+	fields := append([]string{}, p.MaskFields...)
+	for _, r := range p.Routes {
+		if r.Method != "" && !strings.EqualFold(r.Method, method) {
+			continue
+		}
+		if prefix, ok := strings.CutSuffix(r.Path, "*"); ok {
+			if !strings.HasPrefix(route, prefix) {
+				continue
+			}
+		} else if r.Path != route {
+			continue
+		}
+		rp.Skip = rp.Skip || r.Skip
+		if r.RequestBody != nil {
+			rp.CaptureRequest = *r.RequestBody
+		}
+		if r.ResponseBody != nil {
+			rp.CaptureResponse = *r.ResponseBody
+		}
+		fields = append(fields, r.MaskFields...)
+	}
+	for _, f := range fields {
+		rp.masks = append(rp.masks, strings.Split(f, "."))
+	}
+	return rp
+}
+
+// Headers keeps the first value of each header, masking the sensitive ones
+func (rp RoutePolicy) Headers(h http.Header) []byte {
+	headers := map[string]string{}
+	for k, v := range h {
+		if rp.policy.maskHeaders[http.CanonicalHeaderKey(k)] {
+			headers[k] = masked
+		} else {
+			headers[k] = v[0]
+		}
+	}
+	b, _ := json.Marshal(headers)
+	return b
+}
+
+// Capturable reports whether bodies of this content type are kept.
+// Streams and binary types such as uploads never are.
+func (rp RoutePolicy) Capturable(contentType string) bool {
+	if contentType == "" {
+		return true // decided by looking at the bytes
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "text/event-stream" {
+		return false
+	}
+	for _, t := range rp.policy.ContentTypes {
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if strings.EqualFold(t, mediaType) {
+			return true
+		}
+	}
+	return strings.HasSuffix(mediaType, "+json")
+}
+
+// Body turns a captured body into what is stored. body holds at most
+// MaxBodyBytes of a body that was size bytes long in total, or -1 if unknown.
+func (rp RoutePolicy) Body(contentType string, body []byte, size int64, enabled bool) []byte {
+	if size == 0 || (size < 0 && contentType == "") {
+		return []byte("null")
+	}
+	if !enabled {
+		return omitted("disabled for this route", size)
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !rp.Capturable(contentType) {
+		return omitted(mediaType, size)
+	}
+	truncated := size > int64(len(body))
+
+	switch {
+	case mediaType == "application/x-www-form-urlencoded" && !truncated:
+		form, err := url.ParseQuery(string(body))
+		if err == nil {
+			fields := map[string]interface{}{}
+			for k, v := range form {
+				if rp.masked([]string{k}) {
+					fields[k] = masked
+				} else {
+					fields[k] = v
+				}
+			}
+			b, _ := json.Marshal(fields)
+			return b
+		}
+
+	case json.Valid(body):
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err == nil {
+			b, _ := json.Marshal(rp.mask(v, nil))
+			return b
+		}
+
+	case truncated && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") || mediaType == ""):
+		// Cut-off JSON can't be masked, so none of it is kept
+		return omitted("truncated JSON", size)
+	}
+
+	if !utf8.Valid(body) {
+		return omitted("binary", size)
+	}
+	if truncated {
+		b, _ := json.Marshal(map[string]interface{}{"truncated": true, "size": size, "text": string(body)})
+		return b
+	}
+	b, _ := json.Marshal(string(body))
+	return b
+}
+
+func omitted(reason string, size int64) []byte {
+	o := map[string]interface{}{"omitted": reason}
+	if size >= 0 {
+		o["size"] = size
+	}
+	b, _ := json.Marshal(o)
+	return b
+}
+
+// mask replaces the values of masked fields in a decoded JSON value
+func (rp RoutePolicy) mask(v interface{}, path []string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			p := append(path[:len(path):len(path)], k)
+			if rp.masked(p) {
+				v[k] = masked
+			} else {
+				v[k] = rp.mask(child, p)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = rp.mask(child, path)
+		}
+	}
+	return v
+}
+
+func (rp RoutePolicy) masked(path []string) bool {
+	for _, m := range rp.masks {
+		if matchPath(m, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath matches a mask against the field names leading to a value
+func matchPath(mask, path []string) bool {
+	if mask[0] == "$" {
+		mask = mask[1:]
+		if len(mask) != len(path) {
+			return false
+		}
+	} else if len(mask) > len(path) {
+		return false
+	} else {
+		path = path[len(path)-len(mask):]
+	}
+	for i := range mask {
+		if mask[i] != "*" && !strings.EqualFold(mask[i], path[i]) {
+			return false
+		}
+	}
+	return true
+}
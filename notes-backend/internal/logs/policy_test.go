@@ -0,0 +1,171 @@
+package logs
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func defaultPolicyFor(t *testing.T, method, route string) RoutePolicy {
+	t.Helper()
+	p, err := ParsePolicy([]byte(defaultPolicy))
+	if err != nil {
+		t.Fatalf("default policy: %v", err)
+	}
+	return p.For(method, route)
+}
+
+func decode(t *testing.T, b []byte) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatalf("stored body %s is not JSON: %v", b, err)
+	}
+	return v
+}
+
+func TestBodyMasksDefaultFields(t *testing.T) {
+	rp := defaultPolicyFor(t, "POST", "/login")
+	body := `{"username":"alice","password":"hunter2","nested":{"refresh_token":"r","list":[{"code":"123456","ok":1}]}}`
+
+	got := decode(t, rp.Body("application/json", []byte(body), int64(len(body)), true))
+	want := decode(t, []byte(`{"username":"alice","password":"*****","nested":{"refresh_token":"*****","list":[{"code":"*****","ok":1}]}}`))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBodyRouteMasks(t *testing.T) {
+	body := `{"title":"Secret plans","body":"...","category_id":3}`
+
+	got := decode(t, defaultPolicyFor(t, "POST", "/notes").Body("application/json", []byte(body), int64(len(body)), true))
+	want := decode(t, []byte(`{"title":"*****","body":"*****","category_id":3}`))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("/notes: got %v, want %v", got, want)
+	}
+
+	// Other routes keep titles
+	got = decode(t, defaultPolicyFor(t, "POST", "/categories").Body("application/json", []byte(body), int64(len(body)), true))
+	if got.(map[string]interface{})["title"] != "Secret plans" {
+		t.Errorf("/categories: got %v", got)
+	}
+}
+
+func TestMaskPaths(t *testing.T) {
+	p, err := ParsePolicy([]byte(`
+max_body_bytes: 1024
+capture_content_types: [application/json]
+mask_fields: ["$.id", "user.name", "$.items.*"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp := p.For("GET", "/x")
+	body := `{"id":1,"user":{"id":2,"name":"a"},"owner":{"user":{"name":"b"}},"name":"c","items":[{"k":1,"v":2}]}`
+
+	got := decode(t, rp.Body("application/json", []byte(body), int64(len(body)), true))
+	want := decode(t, []byte(`{"id":"*****","user":{"id":2,"name":"*****"},"owner":{"user":{"name":"*****"}},"name":"c","items":[{"k":"*****","v":"*****"}]}`))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBodyForms(t *testing.T) {
+	rp := defaultPolicyFor(t, "POST", "/account-exports/download")
+	body := "token=abc&format=zip"
+
+	got := decode(t, rp.Body("application/x-www-form-urlencoded", []byte(body), int64(len(body)), true))
+	want := decode(t, []byte(`{"token":"*****","format":["zip"]}`))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBodyNotKept(t *testing.T) {
+	rp := defaultPolicyFor(t, "POST", "/login")
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		size        int64
+		enabled     bool
+		want        string
+	}{
+		{"empty", "application/json", "", 0, true, `null`},
+		{"disabled", "application/json", `{"a":1}`, 7, false, `{"omitted":"disabled for this route","size":7}`},
+		{"upload", "multipart/form-data; boundary=x", "--x", 3, true, `{"omitted":"multipart/form-data","size":3}`},
+		{"stream", "text/event-stream", "data: x", -1, true, `{"omitted":"text/event-stream"}`},
+		// Cut-off JSON could hide a password past the cut, so none is kept
+		{"truncated JSON", "application/json", `{"password":"hun`, 100, true, `{"omitted":"truncated JSON","size":100}`},
+		{"binary", "", "\xff\xfe\x00", 3, true, `{"omitted":"binary","size":3}`},
+		{"truncated text", "text/plain", "hello", 10, true, `{"size":10,"text":"hello","truncated":true}`},
+		{"text", "text/plain", "hello", 5, true, `"hello"`},
+	}
+	for _, tt := range tests {
+		got := rp.Body(tt.contentType, []byte(tt.body), tt.size, tt.enabled)
+		if string(got) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	rp := defaultPolicyFor(t, "GET", "/notes")
+	h := http.Header{}
+	h.Set("Authorization", "Bearer secret")
+	h.Set("Cookie", "oidc_binding=x")
+	h.Set("X-Request-ID", "abc")
+
+	got := string(rp.Headers(h))
+	if strings.Contains(got, "secret") || strings.Contains(got, "oidc_binding") {
+		t.Errorf("headers leak credentials: %s", got)
+	}
+	if !strings.Contains(got, `"X-Request-Id":"abc"`) {
+		t.Errorf("headers lost X-Request-ID: %s", got)
+	}
+}
+
+func TestRouteRules(t *testing.T) {
+	if rp := defaultPolicyFor(t, "GET", "/health"); !rp.Skip {
+		t.Error("/health is logged")
+	}
+	if rp := defaultPolicyFor(t, "GET", "/p/:slug"); rp.CaptureResponse || !rp.CaptureRequest {
+		t.Errorf("/p/:slug: request %v, response %v", rp.CaptureRequest, rp.CaptureResponse)
+	}
+
+	p, err := ParsePolicy([]byte(`
+max_body_bytes: 1024
+routes:
+  - path: /notes*
+    request_body: false
+  - method: POST
+    path: /notes
+    request_body: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.For("GET", "/notes").CaptureRequest {
+		t.Error("GET /notes captures the request")
+	}
+	if !p.For("POST", "/notes").CaptureRequest {
+		t.Error("the later POST rule didn't override the prefix rule")
+	}
+	if !p.For("GET", "/categories").CaptureRequest {
+		t.Error("an unrelated route was affected")
+	}
+}
+
+func TestParsePolicyErrors(t *testing.T) {
+	for _, bad := range []string{
+		"max_body_bytes: 0",
+		"max_body_bytes: 10\nroutes:\n  - skip: true",
+		"max_body_bytes: [",
+	} {
+		if _, err := ParsePolicy([]byte(bad)); err == nil {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package logs
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The logs table is partitioned by month into tables named logs_YYYY_MM,
+// so old logs go by dropping a table instead of deleting rows.
+
+// lockClass and lockMaintenance name the advisory lock that keeps instances
+// from managing partitions at the same time
+const (
+	lockClass       = 1002
+	lockMaintenance = 1
+)
+
+// monthsAhead is how many months of partitions are created in advance, so
+// logs can always be written even if the job stops running for a while
+const monthsAhead = 2
+
+const partitionLayout = "logs_2006_01"
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// CreatePartitions makes sure the partitions for this month and the next
+// ones exist. It has to succeed before logs are written.
+func CreatePartitions(db *sql.DB, now time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1, $2)`, lockClass, lockMaintenance); err != nil {
+		return err
+	}
+	if err := createPartitions(tx, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func createPartitions(tx *sql.Tx, now time.Time) error {
+	month := monthStart(now)
+	for i := 0; i <= monthsAhead; i++ {
+		from, to := month.AddDate(0, i, 0), month.AddDate(0, i+1, 0)
+		_, err := tx.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s PARTITION OF logs FOR VALUES FROM ('%s') TO ('%s')`,
+			pq.QuoteIdentifier(from.Format(partitionLayout)), from.Format(time.DateOnly), to.Format(time.DateOnly)))
+		if err != nil {
+			return fmt.Errorf("creating log partition for %s: %w", from.Format("2006-01"), err)
+		}
+	}
+	return nil
+}
+
+// StartRetention creates upcoming partitions and drops the ones whose
+// logs are all older than retention. If archiveDir is set, each partition
+// is first written there as a gzipped JSONL file, and it is only dropped
+// once that worked.
+func StartRetention(db *sql.DB, retention time.Duration, archiveDir string, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := maintain(db, time.Now(), retention, archiveDir); err != nil {
+				fmt.Printf("❌ Log maintenance failed: %v\n", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// maintain runs with the advisory lock held by its connection rather than
+// a transaction, so that each partition can be retired in transactions of
+// its own.
+func maintain(db *sql.DB, now time.Time, retention time.Duration, archiveDir string) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Another instance is already at it
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1, $2)`, lockClass, lockMaintenance).Scan(&locked); err != nil || !locked {
+		return err
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1, $2)`, lockClass, lockMaintenance)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := createPartitions(tx, now); err != nil {
+		return err
+	}
+	expired, err := expiredPartitions(tx, now.Add(-retention))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, p := range expired {
+		if err := retirePartition(ctx, conn, p, archiveDir); err != nil {
+			return err
+		}
+		fmt.Printf("Dropped log partition %s\n", p.name)
+	}
+	return nil
+}
+
+type partition struct {
+	name     string
+	attached bool // false once detached by a run that didn't finish
+}
+
+// expiredPartitions lists the partitions that end before cutoff, oldest
+// first, including ones already detached from logs
+func expiredPartitions(tx *sql.Tx, cutoff time.Time) ([]partition, error) {
+	rows, err := tx.Query(`
+		SELECT c.relname, i.inhrelid IS NOT NULL
+		FROM pg_class c
+		LEFT JOIN pg_inherits i ON i.inhrelid = c.oid AND i.inhparent = 'logs'::regclass
+		WHERE c.relkind = 'r' AND c.relnamespace = (SELECT oid FROM pg_namespace WHERE nspname = current_schema())
+			AND c.relname LIKE 'logs\_%'
+		ORDER BY c.relname
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var expired []partition
+	for rows.Next() {
+		var p partition
+		if err := rows.Scan(&p.name, &p.attached); err != nil {
+			return nil, err
+		}
+		month, err := time.Parse(partitionLayout, p.name)
+		if err != nil {
+			continue // not one of ours
+		}
+		if !month.AddDate(0, 1, 0).After(cutoff) {
+			expired = append(expired, p)
+		}
+	}
+	return expired, rows.Err()
+}
+
+// retirePartition archives and drops an expired partition. It is detached
+// first, on its own, so logs is only locked for the detach and not while
+// the archive is written. If archiving fails the table stays detached, and
+// the next run tries again.
+func retirePartition(ctx context.Context, conn *sql.Conn, p partition, archiveDir string) error {
+	if p.attached {
+		if _, err := conn.ExecContext(ctx, `ALTER TABLE logs DETACH PARTITION `+pq.QuoteIdentifier(p.name)); err != nil {
+			return fmt.Errorf("detaching %s: %w", p.name, err)
+		}
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if archiveDir != "" {
+		if err := archivePartition(tx, p.name, archiveDir); err != nil {
+			return fmt.Errorf("archiving %s: %w", p.name, err)
+		}
+	}
+	if _, err := tx.Exec(`DROP TABLE ` + pq.QuoteIdentifier(p.name)); err != nil {
+		return fmt.Errorf("dropping %s: %w", p.name, err)
+	}
+	return tx.Commit()
+}
+
+// archivePartition writes every entry of a partition to dir/<name>.jsonl.gz,
+// one Entry per line. The file only appears once it is complete.
+func archivePartition(tx *sql.Tx, name, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, name+".jsonl.gz")
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	rows, err := tx.Query(`
+		SELECT id, method, endpoint, status_code, user_id, request_id, created_at,
+			request_headers, request_body, response_body
+		FROM ` + pq.QuoteIdentifier(name) + `
+		ORDER BY created_at, id
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	zw := gzip.NewWriter(tmp)
+	enc := json.NewEncoder(zw)
+	for rows.Next() {
+		var l Entry
+		var reqHeaders, reqBody, resBody []byte
+		if err := rows.Scan(&l.ID, &l.Method, &l.Endpoint, &l.StatusCode, &l.UserID, &l.RequestID, &l.CreatedAt,
+			&reqHeaders, &reqBody, &resBody); err != nil {
+			return err
+		}
+		l.RequestHeaders = rawJSON(reqHeaders)
+		l.RequestBody = rawJSON(reqBody)
+		l.ResponseBody = rawJSON(resBody)
+		if err := enc.Encode(l); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
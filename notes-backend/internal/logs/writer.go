@@ -0,0 +1,218 @@
+package logs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Record is one request log row
+type Record struct {
+	Method         string
+	Endpoint       string
+	RequestHeaders []byte // JSON
+	RequestBody    []byte // JSON
+	ResponseBody   []byte // JSON
+	StatusCode     int
+	UserID         int       // 0 when not logged in
+	RequestID      string    // empty when unknown
+	CreatedAt      time.Time // stored as UTC, like the partition bounds
+}
+
+// Writer stores request logs in the background. Records wait in a bounded
+// queue and a single worker inserts them in batches with COPY, so logging
+// never holds more than one connection however busy the server is. When the
+// queue is full, records are dropped and counted instead of slowing down
+// requests.
+type Writer struct {
+	db            *sql.DB
+	queue         chan Record
+	batchSize     int
+	flushInterval time.Duration
+
+	mu     sync.RWMutex // guards closed against Write racing Close
+	closed bool
+	done   chan struct{}
+
+	written  atomic.Uint64
+	dropped  atomic.Uint64 // queue full
+	failed   atomic.Uint64 // lost to database errors
+	reported uint64        // dropped+failed last logged, worker only
+}
+
+// NewWriter starts a writer holding up to queueSize records, which writes
+// whenever batchSize records are waiting or flushInterval has passed.
+func NewWriter(db *sql.DB, queueSize, batchSize int, flushInterval time.Duration) *Writer {
+	w := &Writer{
+		db:            db,
+		queue:         make(chan Record, queueSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		done:          make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Write queues a record without blocking. It reports false if the record
+// was dropped.
+func (w *Writer) Write(r Record) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		w.dropped.Add(1)
+		return false
+	}
+	select {
+	case w.queue <- r:
+		return true
+	default:
+		w.dropped.Add(1)
+		return false
+	}
+}
+
+// Close stops accepting records and waits until the queued ones are written
+// or ctx is done.
+func (w *Writer) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d log records not written: %w", len(w.queue), ctx.Err())
+	}
+}
+
+// Stats are the writer's counters since startup
+type Stats struct {
+	Queued  int    `json:"queued"`
+	Written uint64 `json:"written"`
+	Dropped uint64 `json:"dropped"`
+	Failed  uint64 `json:"failed"`
+}
+
+func (w *Writer) Stats() Stats {
+	return Stats{
+		Queued:  len(w.queue),
+		Written: w.written.Load(),
+		Dropped: w.dropped.Load(),
+		Failed:  w.failed.Load(),
+	}
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Record, 0, w.batchSize)
+	for {
+		select {
+		case r, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, r)
+			if len(batch) < w.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		w.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+func (w *Writer) flush(batch []Record) {
+	err := w.insert(batch)
+	var pqErr *pq.Error
+	if err != nil && len(batch) > 1 && errors.As(err, &pqErr) {
+		// One bad record fails the whole COPY, so write them one by one
+		for _, r := range batch {
+			w.flush([]Record{r})
+		}
+		return
+	}
+	if err != nil {
+		w.failed.Add(uint64(len(batch)))
+		fmt.Printf("❌ Failed to write %d log records: %v\n", len(batch), err)
+	} else {
+		w.written.Add(uint64(len(batch)))
+	}
+
+	if lost := w.dropped.Load() + w.failed.Load(); lost > w.reported {
+		fmt.Printf("⚠️ %d log records lost so far (%d dropped, queue full)\n", lost, w.dropped.Load())
+		w.reported = lost
+	}
+}
+
+func (w *Writer) insert(batch []Record) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(pq.CopyIn("logs",
+		"method", "endpoint", "request_headers", "request_body", "response_body",
+		"status_code", "user_id", "request_id", "created_at"))
+	if err != nil {
+		return err
+	}
+	for _, r := range batch {
+		if _, err := stmt.Exec(r.Method, r.Endpoint,
+			jsonColumn(r.RequestHeaders), jsonColumn(r.RequestBody), jsonColumn(r.ResponseBody),
+			r.StatusCode, nullInt(r.UserID), nullString(r.RequestID), r.CreatedAt.UTC()); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// jsonColumn passes JSON to COPY as text; []byte would be sent as bytea
+func jsonColumn(b []byte) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
+
+func nullInt(n int) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,144 @@
+package mailer
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpStandIn is a minimal SMTP server that accepts one message, like a
+// local Mailpit without STARTTLS or AUTH
+type smtpStandIn struct {
+	ln       net.Listener
+	received chan delivery
+}
+
+type delivery struct {
+	from string
+	to   []string
+	data string
+}
+
+func startSMTPStandIn(t *testing.T) *smtpStandIn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStandIn{ln: ln, received: make(chan delivery, 1)}
+	t.Cleanup(func() { ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpStandIn) serve() {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ESMTP")
+
+	var d delivery
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			d.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			d.to = append(d.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			d.data = data.String()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			s.received <- d
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	s := startSMTPStandIn(t)
+	host, _, _ := net.SplitHostPort(s.ln.Addr().String())
+	m := &SMTPMailer{Addr: s.ln.Addr().String(), Host: host, From: "Notes <no-reply@example.com>"}
+
+	err := m.Send(Message{
+		To:      "alice@example.com",
+		Subject: "Reset your password\r\nBcc: mallory@example.com",
+		Body:    "Open this link:\n\nhttp://localhost:3000/reset-password?token=abc\n",
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	var d delivery
+	select {
+	case d = <-s.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message delivered")
+	}
+
+	if d.from != "no-reply@example.com" {
+		t.Errorf("MAIL FROM = %q, want the bare sender address", d.from)
+	}
+	if len(d.to) != 1 || d.to[0] != "alice@example.com" {
+		t.Errorf("RCPT TO = %q, want only alice@example.com", d.to)
+	}
+	for _, want := range []string{
+		"From: Notes <no-reply@example.com>\r\n",
+		"To: alice@example.com\r\n",
+		"Subject: Reset your passwordBcc: mallory@example.com\r\n",
+		"\r\n\r\nOpen this link:\r\n\r\nhttp://localhost:3000/reset-password?token=abc\r\n",
+	} {
+		if !strings.Contains(d.data, want) {
+			t.Errorf("message is missing %q:\n%s", want, d.data)
+		}
+	}
+	if strings.Contains(d.data, "\r\nBcc:") {
+		t.Errorf("subject added a header:\n%s", d.data)
+	}
+}
+
+func TestSMTPMailerSendUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	m := &SMTPMailer{Addr: addr, Host: "127.0.0.1", From: "no-reply@example.com"}
+	if err := m.Send(Message{To: "alice@example.com", Subject: "Hi", Body: "Hi"}); err == nil {
+		t.Fatal("Send succeeded without a server")
+	}
+}
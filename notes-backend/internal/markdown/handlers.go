@@ -0,0 +1,405 @@
+package markdown
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"notes-backend/internal/images"
+	"notes-backend/internal/notes"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	maxImportSize   = 100 << 20 // whole archive
+	maxUnpackedSize = 1 << 30   // everything in the archive, uncompressed
+	maxNoteFileSize = 10 << 20  // a single .md file
+)
+
+type exportNote struct {
+	id     int
+	fm     FrontMatter
+	body   string
+	images []string // local upload paths
+}
+
+// ExportHandler - GET /export
+// Streams a zip with one Markdown file per note under notes/ and the
+// note images under images/<note id>/, which the note bodies link to.
+func ExportHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		exported, err := loadExport(db, userID, false)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notes"})
+			return
+		}
+
+		filename := fmt.Sprintf("notes-export-%s.zip", time.Now().Format("2006-01-02"))
+		c.Header("Content-Type", "application/zip")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		c.Status(http.StatusOK)
+
+		// Headers are already sent, so failures from here on can only be logged
+		zw := zip.NewWriter(c.Writer)
+		for _, n := range exported {
+			if err := writeNote(zw, "notes", n); err != nil {
+				fmt.Printf("❌ Export failed for note %d: %v\n", n.id, err)
+				break
+			}
+		}
+		if err := zw.Close(); err != nil {
+			fmt.Printf("❌ Failed to finish export: %v\n", err)
+		}
+	}
+}
+
+// Queryer is satisfied by both *sql.DB and *sql.Tx
+type Queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// WriteAccountArchive writes everything a user owns to w: live notes as in
+// /export, trashed notes under trash/, and every category, including empty
+// ones, in categories.json. Unlike ExportHandler it fails on any error,
+// because it is the last copy before the account is deleted.
+func WriteAccountArchive(db Queryer, userID int, w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	for _, trashed := range []bool{false, true} {
+		exported, err := loadExport(db, userID, trashed)
+		if err != nil {
+			return err
+		}
+		dir := "notes"
+		if trashed {
+			dir = "trash"
+		}
+		for _, n := range exported {
+			if err := writeNote(zw, dir, n); err != nil {
+				return fmt.Errorf("note %d: %w", n.id, err)
+			}
+		}
+	}
+
+	rows, err := db.Query(`SELECT name, created_at FROM categories WHERE user_id=$1 ORDER BY name`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	type category struct {
+		Name      string    `json:"name"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	cats := []category{}
+	for rows.Next() {
+		var cat category
+		if err := rows.Scan(&cat.Name, &cat.CreatedAt); err != nil {
+			return err
+		}
+		cats = append(cats, cat)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	cw, err := zw.Create("categories.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(cw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(cats); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// loadExport reads a user's notes, either live or trashed, with their images
+func loadExport(db Queryer, userID int, trashed bool) ([]*exportNote, error) {
+	rows, err := db.Query(`
+		SELECT n.id, n.title, COALESCE(n.body, ''), COALESCE(cat.name, ''), n.is_favorite, n.visibility,
+			n.created_at, n.updated_at,
+			ARRAY(SELECT t.name FROM note_tags nt JOIN tags t ON t.id = nt.tag_id WHERE nt.note_id = n.id ORDER BY t.name)
+		FROM notes n
+		LEFT JOIN categories cat ON cat.id = n.category_id
+		WHERE n.user_id = $1 AND (n.deleted_at IS NOT NULL) = $2
+		ORDER BY n.id
+	`, userID, trashed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exported []*exportNote
+	byID := map[int]*exportNote{}
+	for rows.Next() {
+		n := &exportNote{}
+		if err := rows.Scan(&n.id, &n.fm.Title, &n.body, &n.fm.Category, &n.fm.Favorite, &n.fm.Visibility,
+			&n.fm.CreatedAt, &n.fm.UpdatedAt, pq.Array(&n.fm.Tags)); err != nil {
+			return nil, err
+		}
+		exported = append(exported, n)
+		byID[n.id] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	imgRows, err := db.Query(`
+		SELECT i.id, i.note_id, i.url
+		FROM images i
+		JOIN notes n ON n.id = i.note_id
+		WHERE n.user_id = $1 AND (n.deleted_at IS NOT NULL) = $2
+		ORDER BY i.id
+	`, userID, trashed)
+	if err != nil {
+		return nil, err
+	}
+	defer imgRows.Close()
+
+	for imgRows.Next() {
+		var imageID, noteID int
+		var url string
+		if err := imgRows.Scan(&imageID, &noteID, &url); err != nil {
+			return nil, err
+		}
+		if n, ok := byID[noteID]; ok {
+			n.addImage(imageID, images.LocalPath(url))
+		}
+	}
+	return exported, imgRows.Err()
+}
+
+// addImage puts an image under images/<note id>/ and points the body's links
+// at that copy, which import maps back to the image it creates
+func (n *exportNote) addImage(imageID int, local string) {
+	name := fmt.Sprintf("images/%d/%s", n.id, path.Base(local))
+	n.images = append(n.images, local)
+	n.fm.Images = append(n.fm.Images, name)
+	n.body = replaceLink(n.body, images.URL(n.id, imageID), name)
+}
+
+// replaceLink replaces link in body with to, leaving longer links that
+// merely start with it, such as image 12 when replacing image 1
+func replaceLink(body, link, to string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(body, link)
+		if i < 0 {
+			b.WriteString(body)
+			return b.String()
+		}
+		end := i + len(link)
+		b.WriteString(body[:i])
+		if end < len(body) && body[end] >= '0' && body[end] <= '9' {
+			b.WriteString(link)
+		} else {
+			b.WriteString(to)
+		}
+		body = body[end:]
+	}
+}
+
+func writeNote(zw *zip.Writer, dir string, n *exportNote) error {
+	doc, err := Render(n.fm, n.body)
+	if err != nil {
+		return err
+	}
+
+	w, err := zw.Create(fmt.Sprintf("%s/%d-%s.md", dir, n.id, Slugify(n.fm.Title)))
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(doc); err != nil {
+		return err
+	}
+
+	for i, local := range n.images {
+		if err := copyFileToZip(zw, n.fm.Images[i], local); err != nil {
+			// A missing upload shouldn't sink the whole export
+			fmt.Printf("❌ Skipping image %s: %v\n", local, err)
+		}
+	}
+	return nil
+}
+
+func copyFileToZip(zw *zip.Writer, name, local string) error {
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// ImportError describes a file that could not be imported
+type ImportError struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// ImportHandler - POST /import
+// Takes an archive produced by /export (form field "archive") and recreates
+// its categories, notes and images for the calling user.
+func ImportHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+		file, err := c.FormFile("archive")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Zip archive is required"})
+			return
+		}
+
+		src, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Zip archive is required"})
+			return
+		}
+		defer src.Close()
+
+		zr, err := zip.NewReader(src, file.Size)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid zip archive"})
+			return
+		}
+		// archive/zip won't read past the sizes in the headers, so their
+		// sum bounds what the import can write
+		var unpacked uint64
+		for _, f := range zr.File {
+			unpacked += f.UncompressedSize64
+		}
+		if unpacked > maxUnpackedSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Archive unpacks to more than %d MB", maxUnpackedSize>>20)})
+			return
+		}
+
+		imported, errs := ImportArchive(db, userID, zr)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "Import finished",
+			"imported": imported,
+			"errors":   errs,
+		})
+	}
+}
+
+// ImportArchive imports every notes/*.md file of an export archive. Each
+// note is imported in its own transaction so one bad file doesn't stop the rest.
+func ImportArchive(db *sql.DB, userID int, zr *zip.Reader) (int, []ImportError) {
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	imported := 0
+	errs := []ImportError{}
+	for _, f := range zr.File {
+		if !strings.HasPrefix(f.Name, "notes/") || !strings.HasSuffix(f.Name, ".md") {
+			continue
+		}
+		if err := importNote(db, userID, f, files); err != nil {
+			errs = append(errs, ImportError{File: f.Name, Error: err.Error()})
+			continue
+		}
+		imported++
+	}
+	return imported, errs
+}
+
+func importNote(db *sql.DB, userID int, f *zip.File, files map[string]*zip.File) error {
+	doc, err := readZipFile(f, maxNoteFileSize)
+	if err != nil {
+		return err
+	}
+	n, err := importedNote(f.Name, doc, files)
+	if err != nil {
+		return err
+	}
+	_, err = notes.ImportNote(db, userID, n)
+	return err
+}
+
+// importedNote reads an exported Markdown file. The body links its images
+// by their path in the archive, which ImportNote replaces with the URLs of
+// the images it stores.
+func importedNote(name string, doc []byte, files map[string]*zip.File) (notes.ImportedNote, error) {
+	fm, body, err := Parse(doc)
+	if err != nil {
+		return notes.ImportedNote{}, fmt.Errorf("invalid front matter: %w", err)
+	}
+
+	n := notes.ImportedNote{
+		Request:   NoteRequestFrom(fm, body, path.Base(name)),
+		Category:  fm.Category,
+		CreatedAt: fm.CreatedAt,
+		UpdatedAt: fm.UpdatedAt,
+	}
+	for _, image := range fm.Images {
+		zf, ok := files[image]
+		if !ok {
+			return notes.ImportedNote{}, fmt.Errorf("image %s is missing from the archive", image)
+		}
+		// archive/zip won't read past the size in the header, so it is
+		// safe to check it before unpacking
+		n.Images = append(n.Images, notes.ImportedImage{
+			Name:        path.Base(image),
+			Size:        int64(zf.UncompressedSize64),
+			Open:        zf.Open,
+			Placeholder: image,
+		})
+	}
+	return n, nil
+}
+
+// NoteRequestFrom builds the note to create from imported front matter.
+// Shares are not part of an export, so shared notes come back private.
+func NoteRequestFrom(fm FrontMatter, body, fallbackTitle string) notes.NoteRequest {
+	title := strings.TrimSpace(fm.Title)
+	if title == "" {
+		title = strings.TrimSuffix(fallbackTitle, ".md")
+	}
+
+	visibility := fm.Visibility
+	if visibility != "public" {
+		visibility = "private"
+	}
+
+	return notes.NoteRequest{
+		Title:      title,
+		Body:       body,
+		IsFavorite: fm.Favorite,
+		Visibility: visibility,
+		Tags:       fm.Tags,
+	}
+}
+
+func readZipFile(f *zip.File, limit int64) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(limit) {
+		return nil, fmt.Errorf("file is larger than %d bytes", limit)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, limit))
+}
@@ -0,0 +1,97 @@
+package markdown
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"notes-backend/internal/notes"
+)
+
+func TestReplaceLink(t *testing.T) {
+	body := "![a](/notes/7/images/1) ![b](/notes/7/images/12) /notes/7/images/1"
+	want := "![a](images/7/a.png) ![b](/notes/7/images/12) images/7/a.png"
+	if got := replaceLink(body, "/notes/7/images/1", "images/7/a.png"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// An exported note links its images inside the archive, and importing it
+// links them to the images the import creates
+func TestExportImportRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	var locals []string
+	for _, name := range []string{"111_beach.png", "222_map.png"} {
+		local := filepath.Join(dir, name)
+		if err := os.WriteFile(local, []byte("image "+name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		locals = append(locals, local)
+	}
+
+	n := &exportNote{
+		id:   7,
+		fm:   FrontMatter{Title: "Trip"},
+		body: "Beach: ![beach](/notes/7/images/3)\nMap: ![map](/notes/7/images/30)\nAgain: ![beach](/notes/7/images/3)",
+	}
+	n.addImage(3, locals[0])
+	n.addImage(30, locals[1])
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if err := writeNote(zw, "notes", n); err != nil {
+		t.Fatalf("writeNote: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	f := files["notes/7-trip.md"]
+	if f == nil {
+		t.Fatalf("archive has no notes/7-trip.md: %v", files)
+	}
+	doc, err := readZipFile(f, maxNoteFileSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exported := "Beach: ![beach](images/7/111_beach.png)\nMap: ![map](images/7/222_map.png)\nAgain: ![beach](images/7/111_beach.png)"
+	if !bytes.Contains(doc, []byte(exported)) {
+		t.Errorf("exported document:\n%s\nwant body %q", doc, exported)
+	}
+
+	imported, err := importedNote(f.Name, doc, files)
+	if err != nil {
+		t.Fatalf("importedNote: %v", err)
+	}
+	if len(imported.Images) != 2 {
+		t.Fatalf("got %d images, want 2", len(imported.Images))
+	}
+	rc, err := imported.Images[1].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "image 222_map.png" {
+		t.Errorf("second image holds %q", data)
+	}
+
+	// As if the import stored the images as 50 and 51 of note 90
+	got := notes.LinkImages(imported.Request.Body, 90, imported.Images, []int{50, 51})
+	want := "Beach: ![beach](/notes/90/images/50)\nMap: ![map](/notes/90/images/51)\nAgain: ![beach](/notes/90/images/50)"
+	if got != want {
+		t.Errorf("imported body = %q, want %q", got, want)
+	}
+}
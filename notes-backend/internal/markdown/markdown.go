@@ -0,0 +1,93 @@
+package markdown
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FrontMatter is the YAML header of an exported note
+type FrontMatter struct {
+	Title      string    `yaml:"title"`
+	Category   string    `yaml:"category,omitempty"`
+	Favorite   bool      `yaml:"favorite"`
+	Visibility string    `yaml:"visibility"`
+	Tags       []string  `yaml:"tags,omitempty"`
+	CreatedAt  time.Time `yaml:"created_at"`
+	UpdatedAt  time.Time `yaml:"updated_at"`
+	Images     []string  `yaml:"images,omitempty"` // paths inside the archive
+}
+
+const delimiter = "---\n"
+
+// Render returns a Markdown document with fm as its front matter
+func Render(fm FrontMatter, body string) ([]byte, error) {
+	header, err := yaml.Marshal(fm)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(delimiter)
+	buf.Write(header)
+	buf.WriteString(delimiter)
+	buf.WriteString("\n")
+	if body != "" {
+		// Parse strips exactly this newline again
+		buf.WriteString(body)
+		buf.WriteString("\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// Parse splits a Markdown document into its front matter and body.
+// Documents without front matter are returned as a bare body.
+func Parse(doc []byte) (FrontMatter, string, error) {
+	var fm FrontMatter
+	text := strings.ReplaceAll(string(doc), "\r\n", "\n")
+
+	if !strings.HasPrefix(text, delimiter) {
+		return fm, text, nil
+	}
+	rest := text[len(delimiter):]
+
+	end := strings.Index(rest, "\n"+delimiter)
+	if end < 0 {
+		return fm, "", errors.New("front matter is not terminated")
+	}
+	if err := yaml.Unmarshal([]byte(rest[:end+1]), &fm); err != nil {
+		return fm, "", err
+	}
+
+	body := rest[end+1+len(delimiter):]
+	body = strings.TrimPrefix(body, "\n")
+	body = strings.TrimSuffix(body, "\n")
+	return fm, body, nil
+}
+
+// Slugify turns a note title into a safe file name stem
+func Slugify(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteRune('-')
+			dash = true
+		}
+		if b.Len() >= 50 {
+			break
+		}
+	}
+	slug := strings.TrimSuffix(b.String(), "-")
+	if slug == "" {
+		return "note"
+	}
+	return slug
+}
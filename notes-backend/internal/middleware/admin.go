@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireAdmin lets only users with the admin role through. It goes after
+// JWTMiddleware and looks the role up on every request, so demoting someone
+// takes effect immediately.
+func RequireAdmin(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var role string
+		err := db.QueryRow(`SELECT role FROM users WHERE id=$1`, c.GetInt("userID")).Scan(&role)
+		if err != nil && err != sql.ErrNoRows {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check role"})
+			c.Abort()
+			return
+		}
+		if role != "admin" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
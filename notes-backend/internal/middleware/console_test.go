@@ -0,0 +1,19 @@
+package middleware
+
+import "testing"
+
+func TestRedactQuery(t *testing.T) {
+	tests := []struct{ path, want string }{
+		{"/notes", "/notes"},
+		{"/events?ticket=abc", "/events?ticket=REDACTED"},
+		{"/events?last_event_id=5&ticket=abc", "/events?last_event_id=5&ticket=REDACTED"},
+		{"/notes/1/live?token=eyJ.x.y&x=1", "/notes/1/live?token=REDACTED&x=1"},
+		{"/events?tick%65t=abc", "/events?tick%65t=REDACTED"},
+		{"/sync?since=12", "/sync?since=12"},
+	}
+	for _, tt := range tests {
+		if got := redactQuery(tt.path); got != tt.want {
+			t.Errorf("redactQuery(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -1,15 +1,20 @@
 package middleware
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"net/http"
 	"notes-backend/internal/auth"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// JWTMiddleware validates the JWT from the Authorization header
-func JWTMiddleware() gin.HandlerFunc {
+// JWTMiddleware validates the JWT from the Authorization header and checks
+// that it hasn't been revoked. Personal access tokens are accepted too.
+func JWTMiddleware(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the Authorization header
 		authHeader := c.GetHeader("Authorization")
@@ -29,6 +34,20 @@ func JWTMiddleware() gin.HandlerFunc {
 
 		tokenString := parts[1]
 
+		// Personal access tokens are limited to their scopes, see RequireScopes
+		if strings.HasPrefix(tokenString, auth.PersonalTokenPrefix) {
+			userID, scopes, err := auth.ValidatePersonalToken(db, tokenString)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+				c.Abort()
+				return
+			}
+			c.Set("userID", userID)
+			c.Set("scopes", scopes)
+			c.Next()
+			return
+		}
+
 		// Validate token
 		claims, err := auth.ValidateToken(tokenString)
 		if err != nil {
@@ -37,9 +56,101 @@ func JWTMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		revoked, err := auth.IsRevoked(db, claims.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check token"})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			c.Abort()
+			return
+		}
+
 		// Store user ID in context for later use
 		c.Set("userID", claims.UserID)
+		c.Set("jti", claims.ID)
+		c.Set("tokenExpiresAt", claims.ExpiresAt.Time)
 
 		c.Next() // continue to the next handler
 	}
 }
+
+// sessionCheckInterval is how often an open stream checks that its access
+// token hasn't been revoked
+const sessionCheckInterval = 10 * time.Second
+
+// StreamAuthMiddleware is JWTMiddleware for long-lived streams. Clients that
+// cannot set headers, like the browser's EventSource, pass a one-time ticket
+// from POST /stream-tickets as ?ticket= instead of a token. The request's
+// context is cancelled when the access token expires or is revoked, so the
+// stream ends with the session; personal access tokens are only checked
+// when the stream opens.
+func StreamAuthMiddleware(db *sql.DB) gin.HandlerFunc {
+	validate := JWTMiddleware(db)
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "" {
+			validate(c)
+		} else {
+			redeemTicket(db, c)
+		}
+		if c.IsAborted() {
+			return
+		}
+
+		jti := c.GetString("jti")
+		if jti == "" {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithDeadline(c.Request.Context(), c.GetTime("tokenExpiresAt"))
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		go watchSession(ctx, cancel, db, jti, c.GetTime("tokenExpiresAt"))
+
+		c.Next()
+	}
+}
+
+func redeemTicket(db *sql.DB, c *gin.Context) {
+	ticket := c.Query("ticket")
+	if ticket == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing Authorization header or ticket"})
+		c.Abort()
+		return
+	}
+	s, err := auth.RedeemStreamTicket(db, ticket)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired ticket"})
+		c.Abort()
+		return
+	}
+	c.Set("userID", s.UserID)
+	c.Set("jti", s.JTI)
+	c.Set("tokenExpiresAt", s.ExpiresAt)
+}
+
+// watchSession cancels ctx once the access token is revoked; its expiry is
+// the context's deadline
+func watchSession(ctx context.Context, cancel context.CancelFunc, db *sql.DB, jti string, expiresAt time.Time) {
+	ticker := time.NewTicker(sessionCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ok, err := auth.SessionValid(db, jti, expiresAt)
+			if err != nil {
+				// Try again on the next tick
+				fmt.Printf("❌ Failed to check stream session: %v\n", err)
+				continue
+			}
+			if !ok {
+				cancel()
+				return
+			}
+		}
+	}
+}
@@ -2,38 +2,46 @@ package middleware
 
 import (
 	"bytes"
-	"database/sql"
-	"encoding/json"
 	"fmt"
 	"io"
 	"time"
 
+	"notes-backend/internal/logs"
+
 	"github.com/gin-gonic/gin"
 )
 
-func LoggingMiddleware(db *sql.DB) gin.HandlerFunc {
+// LoggingMiddleware records every request and its response. What is kept of
+// them is decided by policy, and records are handed to w, which stores them
+// in the background.
+func LoggingMiddleware(w *logs.Writer, policy *logs.Policy) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
 
-		// Clone request body
-		var reqBody []byte
-		if c.Request.Body != nil {
-			reqBody, _ = io.ReadAll(c.Request.Body)
-			c.Request.Body = io.NopCloser(bytes.NewBuffer(reqBody))
+		rp := policy.For(c.Request.Method, c.FullPath())
+		if rp.Skip {
+			c.Next()
+			return
 		}
 
-		// Mask Authorization header
-		headers := map[string]string{}
-		for k, v := range c.Request.Header {
-			if k == "Authorization" {
-				headers[k] = "*****"
+		// Keep at most MaxBodyBytes of the request body. The handler still
+		// reads all of it, and uploads aren't buffered at all.
+		reqType := c.GetHeader("Content-Type")
+		var reqBody []byte
+		reqSize := c.Request.ContentLength
+		if c.Request.Body != nil && rp.CaptureRequest && rp.Capturable(reqType) {
+			reqBody, _ = io.ReadAll(io.LimitReader(c.Request.Body, int64(rp.MaxBodyBytes)+1))
+			c.Request.Body = readCloser{io.MultiReader(bytes.NewReader(reqBody), c.Request.Body), c.Request.Body}
+			if len(reqBody) > rp.MaxBodyBytes {
+				reqBody = reqBody[:rp.MaxBodyBytes]
+				reqSize = max(reqSize, int64(len(reqBody))+1)
 			} else {
-				headers[k] = v[0]
+				reqSize = int64(len(reqBody))
 			}
 		}
 
 		// Capture response body
-		writer := &bodyWriter{body: bytes.NewBufferString(""), ResponseWriter: c.Writer}
+		writer := &bodyWriter{ResponseWriter: c.Writer, policy: rp, capture: rp.CaptureResponse}
 		c.Writer = writer
 
 		c.Next() // process request
@@ -41,57 +49,61 @@ func LoggingMiddleware(db *sql.DB) gin.HandlerFunc {
 		// Calculate duration
 		duration := time.Since(start)
 
-		go func() {
-			_, err := db.Exec(
-				`INSERT INTO logs (method, endpoint, request_headers, request_body, response_body, status_code, created_at)
-				VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-				c.Request.Method,
-				c.Request.URL.Path,
-				headersToJSON(headers),
-				bytesToJSON(reqBody),
-				bytesToJSON(writer.body.Bytes()),
-				c.Writer.Status(),
-				time.Now(),
-			)
-			if err != nil {
-				fmt.Printf("❌ Failed to insert log: %v\n", err)
-			}
-		}()
+		w.Write(logs.Record{
+			Method:         c.Request.Method,
+			Endpoint:       c.Request.URL.Path,
+			RequestHeaders: rp.Headers(c.Request.Header),
+			RequestBody:    rp.Body(reqType, reqBody, reqSize, rp.CaptureRequest),
+			ResponseBody:   rp.Body(writer.Header().Get("Content-Type"), writer.body.Bytes(), writer.size, rp.CaptureResponse),
+			StatusCode:     c.Writer.Status(),
+			UserID:         c.GetInt("userID"),       // set by JWTMiddleware on authenticated routes
+			RequestID:      c.GetString("requestID"), // set by RequestID
+			CreatedAt:      time.Now(),
+		})
 
 		// Console log for dev
-		fmt.Printf("Request %s %s took %v\n", c.Request.Method, c.Request.URL.Path, duration)
+		fmt.Printf("Request %s %s took %v [%s]\n", c.Request.Method, c.Request.URL.Path, duration, c.GetString("requestID"))
 	}
 }
 
-// Helper to convert map to JSON
-func headersToJSON(h map[string]string) []byte {
-	b, _ := json.Marshal(h)
-	return b
+// readCloser reads the rest of a partly buffered request body
+type readCloser struct {
+	io.Reader
+	io.Closer
 }
 
-func bytesToJSON(b []byte) []byte {
-	if len(b) == 0 {
-		return []byte("null")
-	}
-
-	// Ensure valid UTF-8
-	if !json.Valid(b) {
-		// If it's not valid JSON, wrap it as a string
-		safe := string(b)
-		sanitized, _ := json.Marshal(safe)
-		return sanitized
-	}
-
-	return b
-}
-
-// bodyWriter captures response body
+// bodyWriter captures up to MaxBodyBytes of the response body, if its
+// content type is one the policy keeps
 type bodyWriter struct {
 	gin.ResponseWriter
-	body *bytes.Buffer
+	policy  logs.RoutePolicy
+	capture bool
+	checked bool
+	body    bytes.Buffer
+	size    int64
 }
 
 func (w *bodyWriter) Write(b []byte) (int, error) {
-	w.body.Write(b)
+	w.keep(b)
 	return w.ResponseWriter.Write(b)
 }
+
+func (w *bodyWriter) WriteString(s string) (int, error) {
+	w.keep([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *bodyWriter) keep(b []byte) {
+	// Headers are final by the first write; event streams and files aren't kept
+	if !w.checked {
+		w.checked = true
+		w.capture = w.capture && w.policy.Capturable(w.Header().Get("Content-Type"))
+	}
+	w.size += int64(len(b))
+	if room := w.policy.MaxBodyBytes - w.body.Len(); w.capture && room > 0 {
+		if len(b) > room {
+			b = b[:room]
+		}
+		w.body.Write(b)
+	}
+}
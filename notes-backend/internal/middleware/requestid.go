@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID limits IDs taken from clients to something safe to log
+// and echo back
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID gives every request an ID: the client's X-Request-ID if it sent
+// a usable one, or a new one. The ID is stored in the context as
+// "requestID", sent back in the X-Request-ID header, and added as
+// request_id to every JSON error response, so a user can quote it.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Set("requestID", id)
+		c.Header(RequestIDHeader, id)
+
+		writer := &errorWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		writer.flush(id)
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("reading random bytes: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// errorWriter holds back JSON error responses until the handler is done,
+// so the request ID can be added to them. Everything else passes through.
+type errorWriter struct {
+	gin.ResponseWriter
+	held    bool
+	checked bool
+	body    bytes.Buffer
+}
+
+func (w *errorWriter) Write(b []byte) (int, error) {
+	if !w.checked {
+		w.checked = true
+		w.held = w.Status() >= 400 && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json")
+	}
+	if w.held {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *errorWriter) flush(id string) {
+	if !w.held {
+		return
+	}
+	body := w.body.Bytes()
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err == nil && obj != nil {
+		if _, ok := obj["request_id"]; !ok {
+			obj["request_id"] = id
+			if b, err := json.Marshal(obj); err == nil {
+				body = b
+			}
+		}
+	}
+	w.ResponseWriter.Write(body)
+}
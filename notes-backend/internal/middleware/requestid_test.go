@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func requestIDRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestID())
+	r.GET("/ok", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"status": "ok"}) })
+	r.GET("/fail", func(c *gin.Context) { c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"}) })
+	r.GET("/own-id", func(c *gin.Context) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Conflict", "request_id": "set by handler"})
+	})
+	r.GET("/text", func(c *gin.Context) { c.String(http.StatusInternalServerError, "plain failure") })
+	r.GET("/list", func(c *gin.Context) { c.JSON(http.StatusBadRequest, []string{"a"}) })
+	return r
+}
+
+func get(r http.Handler, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v[0])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequestIDAddedToJSONErrors(t *testing.T) {
+	w := get(requestIDRouter(), "/fail", nil)
+
+	id := w.Header().Get(RequestIDHeader)
+	if !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(id) {
+		t.Fatalf("generated request ID %q", id)
+	}
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status %d, want 404", w.Code)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %q: %v", w.Body.String(), err)
+	}
+	if body["error"] != "Note not found" || body["request_id"] != id {
+		t.Errorf("body = %v, want the error and request_id %s", body, id)
+	}
+}
+
+func TestRequestIDFromClient(t *testing.T) {
+	r := requestIDRouter()
+
+	w := get(r, "/fail", http.Header{RequestIDHeader: {"client-abc.123:x"}})
+	if got := w.Header().Get(RequestIDHeader); got != "client-abc.123:x" {
+		t.Errorf("header = %q, want the client's ID", got)
+	}
+	if !strings.Contains(w.Body.String(), `"request_id":"client-abc.123:x"`) {
+		t.Errorf("body = %s", w.Body.String())
+	}
+
+	// IDs that could garble logs are replaced
+	for _, bad := range []string{"has space", "new\nline", strings.Repeat("a", 129), "<script>"} {
+		w := get(r, "/ok", http.Header{RequestIDHeader: {bad}})
+		if got := w.Header().Get(RequestIDHeader); got == bad || got == "" {
+			t.Errorf("client ID %q: header = %q", bad, got)
+		}
+	}
+}
+
+func TestRequestIDLeavesOtherResponses(t *testing.T) {
+	r := requestIDRouter()
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/ok", `{"status":"ok"}`},
+		{"/own-id", `{"error":"Conflict","request_id":"set by handler"}`},
+		{"/text", "plain failure"},
+		{"/list", `["a"]`},
+	}
+	for _, tt := range tests {
+		w := get(r, tt.path, nil)
+		if got := w.Body.String(); got != tt.want {
+			t.Errorf("%s: body = %s, want %s", tt.path, got, tt.want)
+		}
+		if w.Header().Get(RequestIDHeader) == "" {
+			t.Errorf("%s: no %s header", tt.path, RequestIDHeader)
+		}
+	}
+}
+
+func TestRequestIDInContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestID())
+	var seen string
+	r.GET("/", func(c *gin.Context) {
+		seen = c.GetString("requestID")
+		c.Status(http.StatusNoContent)
+	})
+
+	w := get(r, "/", nil)
+	if seen == "" || seen != w.Header().Get(RequestIDHeader) {
+		t.Errorf("context has %q, header %q", seen, w.Header().Get(RequestIDHeader))
+	}
+}
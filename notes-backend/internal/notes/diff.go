@@ -0,0 +1,107 @@
+package notes
+
+import (
+	"errors"
+	"strings"
+)
+
+// maxDiffCells bounds the LCS table, which has a cell for every pair of
+// changed lines: 1M cells, e.g. 1000 changed lines on each side, is 8 MB.
+const maxDiffCells = 1 << 20
+
+// ErrDiffTooLarge is returned when too many lines changed to diff
+var ErrDiffTooLarge = errors.New("too many changed lines to diff")
+
+// DiffLine is one line of a line-level diff
+type DiffLine struct {
+	Op   string `json:"op"` // "equal", "insert", "delete"
+	Text string `json:"text"`
+}
+
+// DiffLines returns a line-level diff turning a into b, based on the
+// longest common subsequence of their lines. Lines shared at the start and
+// end are left out of the LCS, and the rest may have at most maxDiffCells
+// pairs of lines.
+func DiffLines(a, b string) ([]DiffLine, error) {
+	oldLines := splitLines(a)
+	newLines := splitLines(b)
+
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix &&
+		oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+
+	diff := []DiffLine{}
+	for _, line := range oldLines[:prefix] {
+		diff = append(diff, DiffLine{Op: "equal", Text: line})
+	}
+	middle, err := diffMiddle(oldLines[prefix:len(oldLines)-suffix], newLines[prefix:len(newLines)-suffix])
+	if err != nil {
+		return nil, err
+	}
+	diff = append(diff, middle...)
+	for _, line := range oldLines[len(oldLines)-suffix:] {
+		diff = append(diff, DiffLine{Op: "equal", Text: line})
+	}
+	return diff, nil
+}
+
+func diffMiddle(oldLines, newLines []string) ([]DiffLine, error) {
+	n, m := len(oldLines), len(newLines)
+	if int64(n+1)*int64(m+1) > maxDiffCells {
+		return nil, ErrDiffTooLarge
+	}
+
+	// lcs[i][j] = length of the LCS of oldLines[i:] and newLines[j:]
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	diff := []DiffLine{}
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case oldLines[i] == newLines[j]:
+			diff = append(diff, DiffLine{Op: "equal", Text: oldLines[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{Op: "delete", Text: oldLines[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{Op: "insert", Text: newLines[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		diff = append(diff, DiffLine{Op: "delete", Text: oldLines[i]})
+	}
+	for ; j < m; j++ {
+		diff = append(diff, DiffLine{Op: "insert", Text: newLines[j]})
+	}
+	return diff, nil
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+}
@@ -0,0 +1,95 @@
+package notes
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []DiffLine
+	}{
+		{"both empty", "", "", []DiffLine{}},
+		{"added to empty", "", "a\nb", []DiffLine{{"insert", "a"}, {"insert", "b"}}},
+		{"cleared", "a\nb", "", []DiffLine{{"delete", "a"}, {"delete", "b"}}},
+		{"unchanged", "a\nb", "a\nb", []DiffLine{{"equal", "a"}, {"equal", "b"}}},
+		{
+			"line changed in the middle",
+			"a\nb\nc", "a\nx\nc",
+			[]DiffLine{{"equal", "a"}, {"delete", "b"}, {"insert", "x"}, {"equal", "c"}},
+		},
+		{
+			"lines moved",
+			"a\nb\nc\nd", "b\nc\na\nd",
+			[]DiffLine{{"delete", "a"}, {"equal", "b"}, {"equal", "c"}, {"insert", "a"}, {"equal", "d"}},
+		},
+		{
+			"CRLF and LF compare equal",
+			"a\r\nb", "a\nb\nc",
+			[]DiffLine{{"equal", "a"}, {"equal", "b"}, {"insert", "c"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DiffLines(tt.a, tt.b)
+			if err != nil {
+				t.Fatalf("DiffLines: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffLines(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+// Applying the diff's equal and insert lines must give back b, and its
+// equal and delete lines a
+func TestDiffLinesReconstructs(t *testing.T) {
+	a := "one\ntwo\nthree\nfour\nfive\nsix"
+	b := "zero\none\nthree\nfour\n4.5\nsix\nseven"
+
+	diff, err := DiffLines(a, b)
+	if err != nil {
+		t.Fatalf("DiffLines: %v", err)
+	}
+	var oldLines, newLines []string
+	for _, d := range diff {
+		if d.Op != "insert" {
+			oldLines = append(oldLines, d.Text)
+		}
+		if d.Op != "delete" {
+			newLines = append(newLines, d.Text)
+		}
+	}
+	if got := strings.Join(oldLines, "\n"); got != a {
+		t.Errorf("old side = %q, want %q", got, a)
+	}
+	if got := strings.Join(newLines, "\n"); got != b {
+		t.Errorf("new side = %q, want %q", got, b)
+	}
+}
+
+func TestDiffLinesTooLarge(t *testing.T) {
+	var a, b strings.Builder
+	for i := 0; i < 1500; i++ {
+		a.WriteString("old\n")
+		b.WriteString("new\n")
+	}
+	if _, err := DiffLines(a.String(), b.String()); err != ErrDiffTooLarge {
+		t.Fatalf("err = %v, want ErrDiffTooLarge", err)
+	}
+
+	// Shared lines around a small change don't count against the limit
+	shared := strings.Repeat("same\n", 5000)
+	diff, err := DiffLines(shared+"old\n"+shared, shared+"new\n"+shared)
+	if err != nil {
+		t.Fatalf("DiffLines: %v", err)
+	}
+	// Both shared runs, the changed line on each side and the empty last line
+	if len(diff) != 10003 {
+		t.Errorf("len(diff) = %d, want 10003", len(diff))
+	}
+}
@@ -0,0 +1,126 @@
+package notes
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"notes-backend/internal/categories"
+	"notes-backend/internal/images"
+)
+
+// ImportedNote is a note coming from an export or another app
+type ImportedNote struct {
+	Request   NoteRequest
+	Category  string // created if the user doesn't have it yet
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Images    []ImportedImage
+}
+
+// ImportedImage is a file to store with an imported note
+type ImportedImage struct {
+	Name string
+	Size int64 // as the source declares it; checked before reading
+	Open func() (io.ReadCloser, error)
+	// Placeholder, if set, is replaced in the body by the stored image URL
+	Placeholder string
+}
+
+// ImportNote creates an imported note with its category and images in one
+// transaction, and returns its ID. Images over images.MaxImageSize fail the
+// note.
+func ImportNote(db *sql.DB, userID int, n ImportedNote) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	req := n.Request
+	if req.Visibility != "public" {
+		req.Visibility = "private"
+	}
+	if n.Category != "" {
+		categoryID, err := categories.FindOrCreateCategory(tx, userID, n.Category)
+		if err != nil {
+			return 0, err
+		}
+		req.CategoryID = &categoryID
+	}
+
+	createdAt, updatedAt := n.CreatedAt, n.UpdatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	if updatedAt.IsZero() {
+		updatedAt = createdAt
+	}
+
+	noteID, err := InsertNote(tx, userID, req, createdAt, updatedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	// Files are written before the commit, so clean them up if it fails
+	var stored []string
+	committed := false
+	defer func() {
+		if !committed {
+			images.RemoveFiles(stored)
+		}
+	}()
+
+	var imageIDs []int
+	for _, img := range n.Images {
+		imageID, url, err := storeImportedImage(tx, noteID, img)
+		if err != nil {
+			return 0, fmt.Errorf("image %s: %w", img.Name, err)
+		}
+		stored = append(stored, url)
+		imageIDs = append(imageIDs, imageID)
+	}
+	body := LinkImages(req.Body, noteID, n.Images, imageIDs)
+
+	// Point placeholders at the stored images. The note was just created,
+	// so its only revision is rewritten along with it.
+	if body != req.Body {
+		if _, err := tx.Exec(`UPDATE notes SET body=$1 WHERE id=$2`, body, noteID); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(`UPDATE note_revisions SET body=$1 WHERE note_id=$2`, body, noteID); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	committed = true
+	return noteID, nil
+}
+
+// LinkImages replaces each image's Placeholder in body with the URL of the
+// stored image, imageIDs[i] being the ID images[i] was stored under
+func LinkImages(body string, noteID int, imgs []ImportedImage, imageIDs []int) string {
+	for i, img := range imgs {
+		if img.Placeholder != "" {
+			body = strings.ReplaceAll(body, img.Placeholder, images.URL(noteID, imageIDs[i]))
+		}
+	}
+	return body
+}
+
+func storeImportedImage(tx *sql.Tx, noteID int, img ImportedImage) (int, string, error) {
+	if img.Size > images.MaxImageSize {
+		return 0, "", images.ErrImageTooLarge
+	}
+	rc, err := img.Open()
+	if err != nil {
+		return 0, "", err
+	}
+	defer rc.Close()
+	return images.StoreImage(tx, noteID, img.Name, rc)
+}
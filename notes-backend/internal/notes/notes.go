@@ -1,33 +1,56 @@
 package notes
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"notes-backend/internal/events"
+	"notes-backend/internal/sharing"
+	"notes-backend/internal/tags"
+
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 )
 
+// tagsColumn selects the tag names of the note in the current row
+const tagsColumn = `ARRAY(
+	SELECT t.name FROM note_tags nt JOIN tags t ON t.id = nt.tag_id
+	WHERE nt.note_id = notes.id ORDER BY t.name
+)`
+
 // Struct for creating a new note
 type NoteRequest struct {
-	Title      string `json:"title" binding:"required"`
-	Body       string `json:"body"`
-	CategoryID *int   `json:"category_id"` // optional
-	IsFavorite bool   `json:"is_favorite"`
-	Visibility string `json:"visibility"` // "private", "public", "shared"
+	Title      string   `json:"title" binding:"required"`
+	Body       string   `json:"body"`
+	CategoryID *int     `json:"category_id"` // optional
+	IsFavorite bool     `json:"is_favorite"`
+	Visibility string   `json:"visibility"` // "private", "public", "shared"
+	Tags       []string `json:"tags"`       // tag names, created if missing
 }
 
 // Struct for listing/updating notes
 type Note struct {
-	ID         int       `json:"id"`
-	Title      string    `json:"title"`
-	Body       string    `json:"body"`
-	CategoryID *int      `json:"category_id,omitempty"`
-	IsFavorite bool      `json:"is_favorite"`
-	Visibility string    `json:"visibility"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID         int        `json:"id"`
+	Title      string     `json:"title"`
+	Body       string     `json:"body"`
+	CategoryID *int       `json:"category_id,omitempty"`
+	IsFavorite bool       `json:"is_favorite"`
+	Visibility string     `json:"visibility"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+	Tags       []string   `json:"tags"`
+	PublicSlug *string    `json:"public_slug,omitempty"`
+	Version    int        `json:"version"`
+	Role       string     `json:"role,omitempty"`    // caller's role on a single note
+	Owner      string     `json:"owner,omitempty"`   // owner's username on shared notes
+	Rank       float64    `json:"rank,omitempty"`    // only when searching
+	Snippet    *Snippet   `json:"snippet,omitempty"` // only when searching
 }
 
 func CreateNoteHandler(db *sql.DB) gin.HandlerFunc {
@@ -46,18 +69,24 @@ func CreateNoteHandler(db *sql.DB) gin.HandlerFunc {
 
 		now := time.Now()
 
-		var noteID int
-		err := db.QueryRow(`
-			INSERT INTO notes (user_id, title, body, category_id, is_favorite, visibility, created_at, updated_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-			RETURNING id
-		`, userID, req.Title, req.Body, req.CategoryID, req.IsFavorite, req.Visibility, now, now).Scan(&noteID)
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create note"})
+			return
+		}
+		defer tx.Rollback()
 
+		noteID, err := InsertNote(tx, userID, req, now, now)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create note"})
 			return
 		}
 
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create note"})
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Note created successfully",
 			"note_id": noteID,
@@ -65,6 +94,40 @@ func CreateNoteHandler(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
+// InsertNote creates a note with its tags, public slug and first revision.
+// It is shared by the create handler and the importers, which keep the
+// original timestamps.
+func InsertNote(tx *sql.Tx, userID int, req NoteRequest, createdAt, updatedAt time.Time) (int, error) {
+	var noteID int
+	err := tx.QueryRow(`
+		INSERT INTO notes (user_id, title, body, category_id, is_favorite, visibility, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`, userID, req.Title, req.Body, req.CategoryID, req.IsFavorite, req.Visibility, createdAt, updatedAt).Scan(&noteID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tags.SetNoteTags(tx, userID, noteID, req.Tags); err != nil {
+		return 0, err
+	}
+
+	if err := syncPublicSlug(tx, noteID); err != nil {
+		return 0, err
+	}
+
+	// First revision is the note as created
+	if err := recordRevision(tx, noteID); err != nil {
+		return 0, err
+	}
+
+	if err := events.PublishNote(tx, noteID, "note", noteID, events.Created); err != nil {
+		return 0, err
+	}
+
+	return noteID, nil
+}
+
 func ListNotesHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.GetInt("userID")
@@ -73,43 +136,122 @@ func ListNotesHandler(db *sql.DB) gin.HandlerFunc {
 		favorite := c.Query("favorite")
 		search := c.Query("search")
 		categoryID := c.Query("category_id")
+		tagNames := tags.NormalizeNames(splitTagParams(c.QueryArray("tag")))
+		tagMode := c.DefaultQuery("tag_mode", "and")
+
+		if tagMode != "and" && tagMode != "or" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tag_mode must be 'and' or 'or'"})
+			return
+		}
+
+		// Pagination and sorting
+		limit, err := parseLimit(c.Query("limit"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		sort, err := parseListSort(c.Query("sort"), c.Query("order"), search != "")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var cursor *pageCursor
+		if raw := c.Query("cursor"); raw != "" {
+			cur, err := decodeCursor(raw, sort)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			cursor = &cur
+		}
+		withTotal := c.Query("count") == "true"
 
-		// Base query
-		query := `
-			SELECT id, title, body, category_id, is_favorite, visibility, created_at, updated_at
-			FROM notes
-			WHERE user_id = $1 AND deleted_at IS NULL
-		`
 		args := []interface{}{userID}
 		i := 2
 
+		// Search text is bound once and shared by the filter, rank and snippets
+		columns := ""
+		searchArg := 0
+		if search != "" {
+			searchArg = i
+			columns = searchColumns(searchArg)
+			args = append(args, search)
+			i++
+		}
+
+		// Filters are built separately so the optional count can reuse them
+		where := " WHERE user_id = $1 AND deleted_at IS NULL"
+
 		// Filter: favorites
 		if favorite == "true" {
-			query += fmt.Sprintf(" AND is_favorite = $%d", i)
+			where += fmt.Sprintf(" AND is_favorite = $%d", i)
 			args = append(args, true)
 			i++
 		}
 
-		// Filter: search
+		// Filter: full-text search
 		if search != "" {
-			query += fmt.Sprintf(" AND (title ILIKE $%d OR body ILIKE $%d)", i, i+1)
-			args = append(args, "%"+search+"%", "%"+search+"%")
-			i += 2
+			where += searchCondition(searchArg)
 		}
 
 		// Filter: category
 		if categoryID != "" {
 			if categoryID == "none" {
-				query += " AND category_id IS NULL"
+				where += " AND category_id IS NULL"
 			} else {
-				query += fmt.Sprintf(" AND category_id = $%d", i)
+				where += fmt.Sprintf(" AND category_id = $%d", i)
 				args = append(args, categoryID)
 				i++
 			}
 		}
 
-		// Order
-		query += " ORDER BY created_at DESC"
+		// Filter: tags ("and" needs every tag, "or" any of them)
+		if len(tagNames) > 0 {
+			if tagMode == "and" {
+				where += fmt.Sprintf(` AND id IN (
+					SELECT nt.note_id FROM note_tags nt JOIN tags t ON t.id = nt.tag_id
+					WHERE t.user_id = $1 AND t.name = ANY($%d)
+					GROUP BY nt.note_id
+					HAVING COUNT(DISTINCT t.id) = $%d
+				)`, i, i+1)
+				args = append(args, pq.Array(tagNames), len(tagNames))
+				i += 2
+			} else {
+				where += fmt.Sprintf(` AND EXISTS (
+					SELECT 1 FROM note_tags nt JOIN tags t ON t.id = nt.tag_id
+					WHERE nt.note_id = notes.id AND t.name = ANY($%d)
+				)`, i)
+				args = append(args, pq.Array(tagNames))
+				i++
+			}
+		}
+
+		// Total is opt-in: counting every match is costly on large accounts
+		var total *int
+		if withTotal {
+			var n int
+			if err := db.QueryRow("SELECT COUNT(*) FROM notes"+where, args...).Scan(&n); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count notes"})
+				return
+			}
+			total = &n
+		}
+
+		query := `
+			SELECT id, title, body, category_id, is_favorite, visibility, version, created_at, updated_at, ` + tagsColumn + columns + `
+			FROM notes` + where
+
+		// Keyset pagination: continue after the last note of the previous page
+		if cursor != nil {
+			query += sort.after(searchArg, i)
+			args = append(args, cursor.Value, cursor.ID)
+			i += 2
+		}
+
+		// Fetch one extra row to know whether there is a next page
+		query += sort.orderBy(searchArg)
+		query += fmt.Sprintf(" LIMIT $%d", i)
+		args = append(args, limit+1)
 
 		rows, err := db.Query(query, args...)
 		if err != nil {
@@ -123,11 +265,20 @@ func ListNotesHandler(db *sql.DB) gin.HandlerFunc {
 			var n Note
 			var category sql.NullInt64
 
-			if err := rows.Scan(&n.ID, &n.Title, &n.Body, &category, &n.IsFavorite, &n.Visibility, &n.CreatedAt, &n.UpdatedAt); err != nil {
+			dest := []interface{}{&n.ID, &n.Title, &n.Body, &category, &n.IsFavorite, &n.Visibility, &n.Version, &n.CreatedAt, &n.UpdatedAt, pq.Array(&n.Tags)}
+			if search != "" {
+				n.Snippet = &Snippet{}
+				dest = append(dest, &n.Rank, &n.Snippet.Title, &n.Snippet.Body)
+			}
+
+			if err := rows.Scan(dest...); err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan note"})
 				return
 			}
 
+			if n.Snippet != nil {
+				n.Snippet.escape()
+			}
 			if category.Valid {
 				id := int(category.Int64)
 				n.CategoryID = &id
@@ -136,16 +287,66 @@ func ListNotesHandler(db *sql.DB) gin.HandlerFunc {
 			notes = append(notes, n)
 		}
 
-		c.JSON(http.StatusOK, gin.H{"notes": notes})
+		var nextCursor *string
+		if len(notes) > limit {
+			notes = notes[:limit]
+			next := encodeCursor(sort.cursorFor(notes[limit-1]))
+			nextCursor = &next
+		}
+
+		resp := gin.H{"notes": notes, "next_cursor": nextCursor}
+		if total != nil {
+			resp["total"] = *total
+		}
+		c.JSON(http.StatusOK, resp)
 	}
 }
 
 type UpdateNoteRequest struct {
-	Title      *string `json:"title"`
-	Body       *string `json:"body"`
-	CategoryID *int    `json:"category_id"`
-	IsFavorite *bool   `json:"is_favorite"`
-	Visibility *string `json:"visibility"`
+	Title      *string   `json:"title"`
+	Body       *string   `json:"body"`
+	CategoryID *int      `json:"category_id"`
+	IsFavorite *bool     `json:"is_favorite"`
+	Visibility *string   `json:"visibility"`
+	Tags       *[]string `json:"tags"` // replaces the note's tags when present
+}
+
+// NoteError is a refused change and the status it maps to. Current is set
+// when a precondition failed, so the caller can show the newer copy.
+type NoteError struct {
+	Status  int
+	Message string
+	Current *Note
+}
+
+func (e *NoteError) Error() string {
+	return e.Message
+}
+
+// respondNoteError writes err as the handler's response, falling back to a
+// 500 with message for anything that isn't a NoteError.
+func respondNoteError(c *gin.Context, err error, message string) {
+	if ne, ok := err.(*NoteError); ok {
+		resp := gin.H{"error": ne.Message}
+		if ne.Current != nil {
+			resp["note"] = ne.Current
+		}
+		c.JSON(ne.Status, resp)
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
+
+// ifMatchHeader turns an If-Match header into an UpdateNote/DeleteNote
+// precondition, or nil when the header is absent.
+func ifMatchHeader(c *gin.Context) func(Note) bool {
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		return nil
+	}
+	return func(current Note) bool {
+		return etagMatches(ifMatch, noteETag(current))
+	}
 }
 
 func UpdateNoteHandler(db *sql.DB) gin.HandlerFunc {
@@ -159,63 +360,145 @@ func UpdateNoteHandler(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Build dynamic update query
-		query := "UPDATE notes SET "
-		args := []interface{}{}
-		i := 1
-
-		if req.Title != nil {
-			query += fmt.Sprintf("title=$%d,", i)
-			args = append(args, *req.Title)
-			i++
-		}
-		if req.Body != nil {
-			query += fmt.Sprintf("body=$%d,", i)
-			args = append(args, *req.Body)
-			i++
-		}
-		if req.CategoryID != nil {
-			query += fmt.Sprintf("category_id=$%d,", i)
-			args = append(args, *req.CategoryID)
-			i++
-		}
-		if req.IsFavorite != nil {
-			query += fmt.Sprintf("is_favorite=$%d,", i)
-			args = append(args, *req.IsFavorite)
-			i++
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update note"})
+			return
 		}
-		if req.Visibility != nil {
-			query += fmt.Sprintf("visibility=$%d,", i)
-			args = append(args, *req.Visibility)
-			i++
+		defer tx.Rollback()
+
+		id, version, err := UpdateNote(tx, userID, noteID, req, ifMatchHeader(c))
+		if err != nil {
+			respondNoteError(c, err, "Failed to update note")
+			return
 		}
 
-		if len(args) == 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update note"})
 			return
 		}
 
-		// Update updated_at timestamp
-		query += fmt.Sprintf("updated_at=$%d", i)
-		args = append(args, time.Now())
+		c.Header("ETag", noteETag(Note{ID: id, Version: version}))
+		c.JSON(http.StatusOK, gin.H{"message": "Note updated successfully", "version": version})
+	}
+}
+
+// UpdateNote applies a partial update to a live note the user can edit and
+// returns its id and new version. matches, when set, is checked against the
+// current note first (If-Match, or a sync client's base version).
+func UpdateNote(tx *sql.Tx, userID int, noteID interface{}, req UpdateNoteRequest, matches func(Note) bool) (int, int, error) {
+	// Build dynamic update query
+	query := "UPDATE notes SET "
+	args := []interface{}{}
+	i := 1
+
+	if req.Title != nil {
+		query += fmt.Sprintf("title=$%d,", i)
+		args = append(args, *req.Title)
+		i++
+	}
+	if req.Body != nil {
+		query += fmt.Sprintf("body=$%d,", i)
+		args = append(args, *req.Body)
+		i++
+	}
+	if req.CategoryID != nil {
+		query += fmt.Sprintf("category_id=$%d,", i)
+		args = append(args, *req.CategoryID)
 		i++
+	}
+	if req.IsFavorite != nil {
+		query += fmt.Sprintf("is_favorite=$%d,", i)
+		args = append(args, *req.IsFavorite)
+		i++
+	}
+	if req.Visibility != nil {
+		query += fmt.Sprintf("visibility=$%d,", i)
+		args = append(args, *req.Visibility)
+		i++
+	}
+
+	if len(args) == 0 && req.Tags == nil {
+		return 0, 0, &NoteError{Status: http.StatusBadRequest, Message: "No fields to update"}
+	}
 
-		query += fmt.Sprintf(" WHERE id=$%d AND user_id=$%d AND deleted_at IS NULL", i, i+1)
-		args = append(args, noteID, userID)
+	// Update updated_at timestamp and bump the version used for ETags
+	query += fmt.Sprintf("version=version+1, updated_at=$%d", i)
+	args = append(args, time.Now())
+	i++
+
+	query += fmt.Sprintf(" WHERE id=$%d AND deleted_at IS NULL RETURNING version", i)
+
+	// Lock the note so revision numbers are assigned in order
+	var lockedID int
+	err := tx.QueryRow(`
+		SELECT id FROM notes WHERE id=$1 AND deleted_at IS NULL FOR UPDATE
+	`, noteID).Scan(&lockedID)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, 0, err
+	}
+	args = append(args, lockedID)
+
+	// Owners can change everything, editors only the content
+	role, err := sharing.NoteRole(tx, lockedID, userID)
+	if err == sql.ErrNoRows {
+		return 0, 0, &NoteError{Status: http.StatusNotFound, Message: "Note not found or not owned by user"}
+	} else if err != nil {
+		return 0, 0, err
+	}
+	if !sharing.CanEdit(role) {
+		return 0, 0, &NoteError{Status: http.StatusForbidden, Message: "You only have view access to this note"}
+	}
+	if role != sharing.RoleOwner && (req.CategoryID != nil || req.IsFavorite != nil || req.Visibility != nil || req.Tags != nil) {
+		return 0, 0, &NoteError{Status: http.StatusForbidden, Message: "Only the owner can change category, favorite, visibility or tags"}
+	}
 
-		res, err := db.Exec(query, args...)
+	// Optimistic concurrency: refuse to overwrite a newer copy
+	if matches != nil {
+		current, err := loadNote(tx, lockedID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update note"})
-			return
+			return 0, 0, err
 		}
-		rowsAffected, _ := res.RowsAffected()
-		if rowsAffected == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found or not owned by user"})
-			return
+		if !matches(current) {
+			current.Role = role
+			return 0, 0, &NoteError{Status: http.StatusPreconditionFailed, Message: "Note was modified by someone else", Current: &current}
+		}
+	}
+
+	contentChanged := req.Title != nil || req.Body != nil
+	if contentChanged {
+		if err := ensureBaselineRevision(tx, lockedID); err != nil {
+			return 0, 0, err
 		}
+	}
+
+	var version int
+	if err := tx.QueryRow(query, args...).Scan(&version); err != nil {
+		return 0, 0, err
+	}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Note updated successfully"})
+	if req.Tags != nil {
+		if err := tags.SetNoteTags(tx, userID, lockedID, *req.Tags); err != nil {
+			return 0, 0, err
+		}
 	}
+
+	if req.Visibility != nil {
+		if err := syncPublicSlug(tx, lockedID); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if contentChanged {
+		if err := recordRevision(tx, lockedID); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if err := events.PublishNote(tx, lockedID, "note", lockedID, events.Updated); err != nil {
+		return 0, 0, err
+	}
+	return lockedID, version, nil
 }
 
 func DeleteNoteHandler(db *sql.DB) gin.HandlerFunc {
@@ -223,18 +506,20 @@ func DeleteNoteHandler(db *sql.DB) gin.HandlerFunc {
 		userID := c.GetInt("userID")
 		noteID := c.Param("id")
 
-		res, err := db.Exec(`
-			UPDATE notes
-			SET deleted_at=$1
-			WHERE id=$2 AND user_id=$3 AND deleted_at IS NULL
-		`, time.Now(), noteID, userID)
+		tx, err := db.Begin()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete note"})
 			return
 		}
-		rowsAffected, _ := res.RowsAffected()
-		if rowsAffected == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found or not owned by user"})
+		defer tx.Rollback()
+
+		if err := DeleteNote(tx, userID, noteID, ifMatchHeader(c)); err != nil {
+			respondNoteError(c, err, "Failed to delete note")
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete note"})
 			return
 		}
 
@@ -242,24 +527,58 @@ func DeleteNoteHandler(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
+// DeleteNote moves one of the user's notes to the trash. matches works as
+// in UpdateNote.
+func DeleteNote(tx *sql.Tx, userID int, noteID interface{}, matches func(Note) bool) error {
+	var lockedID int
+	err := tx.QueryRow(`
+		SELECT id FROM notes WHERE id=$1 AND user_id=$2 AND deleted_at IS NULL FOR UPDATE
+	`, noteID, userID).Scan(&lockedID)
+	if err == sql.ErrNoRows {
+		return &NoteError{Status: http.StatusNotFound, Message: "Note not found or not owned by user"}
+	} else if err != nil {
+		return err
+	}
+
+	// Optimistic concurrency: don't trash a copy the caller hasn't seen
+	if matches != nil {
+		current, err := loadNote(tx, lockedID)
+		if err != nil {
+			return err
+		}
+		if !matches(current) {
+			current.Role = sharing.RoleOwner
+			return &NoteError{Status: http.StatusPreconditionFailed, Message: "Note was modified by someone else", Current: &current}
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE notes
+		SET deleted_at=$1, version=version+1
+		WHERE id=$2
+	`, time.Now(), lockedID)
+	if err != nil {
+		return err
+	}
+
+	return events.PublishNote(tx, lockedID, "note", lockedID, events.Deleted)
+}
+
 func GetNoteByIDHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.GetInt("userID")
 		noteID := c.Param("id")
 
-		var n Note
-		var categoryID sql.NullInt64
-
-		query := `
-            SELECT id, title, body, category_id, is_favorite, visibility, created_at, updated_at
-            FROM notes
-            WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
-        `
-		err := db.QueryRow(query, noteID, userID).Scan(
-			&n.ID, &n.Title, &n.Body, &categoryID,
-			&n.IsFavorite, &n.Visibility, &n.CreatedAt, &n.UpdatedAt,
-		)
+		role, err := sharing.NoteRole(db, noteID, userID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch note"})
+			return
+		}
 
+		n, err := loadNote(db, noteID)
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
 			return
@@ -267,12 +586,125 @@ func GetNoteByIDHandler(db *sql.DB) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch note"})
 			return
 		}
+		n.Role = role
 
-		if categoryID.Valid {
-			id := int(categoryID.Int64)
-			n.CategoryID = &id
+		etag := noteETag(n)
+		c.Header("ETag", etag)
+		if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+			c.Status(http.StatusNotModified)
+			return
 		}
 
 		c.JSON(http.StatusOK, n)
 	}
 }
+
+// loadNote fetches a live note by id without any access check
+func loadNote(q sharing.Queryer, noteID interface{}) (Note, error) {
+	var n Note
+	var categoryID sql.NullInt64
+
+	query := `
+		SELECT id, title, body, category_id, is_favorite, visibility, public_slug, version, created_at, updated_at, ` + tagsColumn + `
+		FROM notes
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+	err := q.QueryRow(query, noteID).Scan(
+		&n.ID, &n.Title, &n.Body, &categoryID,
+		&n.IsFavorite, &n.Visibility, &n.PublicSlug, &n.Version, &n.CreatedAt, &n.UpdatedAt, pq.Array(&n.Tags),
+	)
+	if err != nil {
+		return n, err
+	}
+
+	if categoryID.Valid {
+		id := int(categoryID.Int64)
+		n.CategoryID = &id
+	}
+	return n, nil
+}
+
+// noteETag identifies one version of a note
+func noteETag(n Note) string {
+	return fmt.Sprintf(`"%d.%d"`, n.ID, n.Version)
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header value
+// lists etag. We only issue strong tags, so a W/ prefix is ignored.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ListSharedWithMeHandler - GET /notes/shared-with-me
+func ListSharedWithMeHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		rows, err := db.Query(`
+			SELECT notes.id, notes.title, notes.body, notes.is_favorite, notes.visibility, notes.version,
+				notes.created_at, notes.updated_at, `+tagsColumn+`, s.role, u.username
+			FROM note_shares s
+			JOIN notes ON notes.id = s.note_id
+			JOIN users u ON u.id = notes.user_id
+			WHERE s.user_id = $1 AND notes.deleted_at IS NULL AND notes.visibility <> 'private'
+			ORDER BY notes.updated_at DESC
+		`, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shared notes"})
+			return
+		}
+		defer rows.Close()
+
+		var notes []Note
+		for rows.Next() {
+			var n Note
+			if err := rows.Scan(&n.ID, &n.Title, &n.Body, &n.IsFavorite, &n.Visibility, &n.Version,
+				&n.CreatedAt, &n.UpdatedAt, pq.Array(&n.Tags), &n.Role, &n.Owner); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan note"})
+				return
+			}
+			notes = append(notes, n)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"notes": notes})
+	}
+}
+
+// newPublicSlug returns an unguessable slug for a public note page
+func newPublicSlug() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// syncPublicSlug gives a public note a slug, keeping an existing one, and
+// revokes it once the note is no longer public.
+func syncPublicSlug(tx *sql.Tx, noteID int) error {
+	slug, err := newPublicSlug()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE notes
+		SET public_slug = CASE WHEN visibility = 'public' THEN COALESCE(public_slug, $2) ELSE NULL END
+		WHERE id = $1
+	`, noteID, slug)
+	return err
+}
+
+// splitTagParams accepts both ?tag=a&tag=b and ?tag=a,b
+func splitTagParams(values []string) []string {
+	var names []string
+	for _, v := range values {
+		names = append(names, strings.Split(v, ",")...)
+	}
+	return names
+}
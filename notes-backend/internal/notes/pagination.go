@@ -0,0 +1,155 @@
+package notes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// listSort is the ordering of a note listing. Ties are broken by id so
+// every row has a unique position a cursor can point at.
+type listSort struct {
+	Field string // "created_at", "updated_at", "title" or "relevance"
+	Desc  bool
+}
+
+// pageCursor is the position of the last note on a page. It is handed to
+// clients base64-encoded and should be treated by them as opaque.
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// parseListSort reads the sort= and order= query params. Relevance is
+// only available (and is the default) when searching.
+func parseListSort(sort, order string, searching bool) (listSort, error) {
+	if sort == "" {
+		sort = "created_at"
+		if searching {
+			sort = "relevance"
+		}
+	}
+
+	s := listSort{Field: sort}
+	switch sort {
+	case "created_at", "updated_at", "relevance":
+		s.Desc = true
+	case "title":
+		s.Desc = false
+	default:
+		return s, errors.New("sort must be one of created_at, updated_at, title, relevance")
+	}
+	if sort == "relevance" && !searching {
+		return s, errors.New("sort=relevance requires a search")
+	}
+
+	switch order {
+	case "":
+	case "asc":
+		s.Desc = false
+	case "desc":
+		s.Desc = true
+	default:
+		return s, errors.New("order must be 'asc' or 'desc'")
+	}
+	return s, nil
+}
+
+// expr is the SQL expression notes are sorted by
+func (s listSort) expr(searchArg int) string {
+	if s.Field == "relevance" {
+		return "ts_rank(search_vector, " + tsQuery(searchArg) + ")"
+	}
+	return s.Field
+}
+
+// sqlType is the type the cursor value is cast to when compared
+func (s listSort) sqlType() string {
+	switch s.Field {
+	case "title":
+		return "text"
+	case "relevance":
+		return "real"
+	default:
+		return "timestamp"
+	}
+}
+
+func (s listSort) orderBy(searchArg int) string {
+	dir := "ASC"
+	if s.Desc {
+		dir = "DESC"
+	}
+	return fmt.Sprintf(" ORDER BY %s %s, id %s", s.expr(searchArg), dir, dir)
+}
+
+// after restricts the listing to notes past the cursor bound to $p and $p+1
+func (s listSort) after(searchArg, p int) string {
+	op := ">"
+	if s.Desc {
+		op = "<"
+	}
+	return fmt.Sprintf(" AND (%s, id) %s ($%d::%s, $%d)", s.expr(searchArg), op, p, s.sqlType(), p+1)
+}
+
+// cursorFor returns the cursor pointing just past n
+func (s listSort) cursorFor(n Note) pageCursor {
+	cur := pageCursor{Sort: s.Field, Desc: s.Desc, ID: n.ID}
+	switch s.Field {
+	case "created_at":
+		cur.Value = n.CreatedAt.Format(time.RFC3339Nano)
+	case "updated_at":
+		cur.Value = n.UpdatedAt.Format(time.RFC3339Nano)
+	case "title":
+		cur.Value = n.Title
+	case "relevance":
+		cur.Value = strconv.FormatFloat(n.Rank, 'g', -1, 64)
+	}
+	return cur
+}
+
+func encodeCursor(cur pageCursor) string {
+	b, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses a cursor and checks it was issued for the same ordering
+func decodeCursor(raw string, s listSort) (pageCursor, error) {
+	var cur pageCursor
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return cur, errors.New("invalid cursor")
+	}
+	if err := json.Unmarshal(b, &cur); err != nil {
+		return cur, errors.New("invalid cursor")
+	}
+	if cur.Sort != s.Field || cur.Desc != s.Desc {
+		return cur, errors.New("cursor does not match the requested sort")
+	}
+	return cur, nil
+}
+
+// parseLimit reads the limit= query param
+func parseLimit(raw string) (int, error) {
+	if raw == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 {
+		return 0, errors.New("limit must be a positive number")
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return limit, nil
+}
@@ -0,0 +1,99 @@
+package notes
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.UTC)
+	n := Note{ID: 42, Title: "Groceries / ünïcode", CreatedAt: created, UpdatedAt: created.Add(time.Hour), Rank: 0.0607927}
+
+	for _, s := range []listSort{
+		{Field: "created_at", Desc: true},
+		{Field: "updated_at", Desc: false},
+		{Field: "title", Desc: false},
+		{Field: "relevance", Desc: true},
+	} {
+		t.Run(s.Field, func(t *testing.T) {
+			want := s.cursorFor(n)
+			raw := encodeCursor(want)
+			if _, err := base64.RawURLEncoding.DecodeString(raw); err != nil {
+				t.Fatalf("cursor %q is not URL-safe base64: %v", raw, err)
+			}
+
+			got, err := decodeCursor(raw, s)
+			if err != nil {
+				t.Fatalf("decodeCursor: %v", err)
+			}
+			if got != want {
+				t.Errorf("decoded %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestCursorKeepsTimestampPrecision(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 45, 123456000, time.UTC)
+	s := listSort{Field: "created_at", Desc: true}
+
+	cur, err := decodeCursor(encodeCursor(s.cursorFor(Note{ID: 1, CreatedAt: created})), s)
+	if err != nil {
+		t.Fatalf("decodeCursor: %v", err)
+	}
+	got, err := time.Parse(time.RFC3339Nano, cur.Value)
+	if err != nil {
+		t.Fatalf("cursor value %q: %v", cur.Value, err)
+	}
+	if !got.Equal(created) {
+		t.Errorf("cursor value = %v, want %v", got, created)
+	}
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+	s := listSort{Field: "created_at", Desc: true}
+	tests := map[string]string{
+		"not base64":       "%%%",
+		"not JSON":         base64.RawURLEncoding.EncodeToString([]byte("nope")),
+		"other sort field": encodeCursor(pageCursor{Sort: "title", Desc: true, Value: "a", ID: 1}),
+		"other direction":  encodeCursor(pageCursor{Sort: "created_at", Desc: false, Value: "2024-01-01T00:00:00Z", ID: 1}),
+	}
+	for name, raw := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := decodeCursor(raw, s); err == nil {
+				t.Errorf("decodeCursor(%q) succeeded", raw)
+			}
+		})
+	}
+}
+
+func TestParseListSort(t *testing.T) {
+	tests := []struct {
+		sort, order string
+		searching   bool
+		want        listSort
+		wantErr     bool
+	}{
+		{"", "", false, listSort{Field: "created_at", Desc: true}, false},
+		{"", "", true, listSort{Field: "relevance", Desc: true}, false},
+		{"title", "", false, listSort{Field: "title", Desc: false}, false},
+		{"title", "desc", false, listSort{Field: "title", Desc: true}, false},
+		{"updated_at", "asc", false, listSort{Field: "updated_at", Desc: false}, false},
+		{"relevance", "", false, listSort{}, true},
+		{"body", "", false, listSort{}, true},
+		{"title", "up", false, listSort{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseListSort(tt.sort, tt.order, tt.searching)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseListSort(%q, %q, %v) succeeded", tt.sort, tt.order, tt.searching)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseListSort(%q, %q, %v) = %+v, %v; want %+v", tt.sort, tt.order, tt.searching, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package notes
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"notes-backend/internal/events"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Revision is a snapshot of a note's title and body
+type Revision struct {
+	Revision  int       `json:"revision"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// recordRevision stores the current title/body of a note as its next revision.
+// Callers must hold a row lock on the note (or have just inserted it).
+func recordRevision(tx *sql.Tx, noteID int) error {
+	_, err := tx.Exec(`
+		INSERT INTO note_revisions (note_id, revision, title, body, created_at)
+		SELECT id,
+			COALESCE((SELECT MAX(revision) FROM note_revisions WHERE note_id = notes.id), 0) + 1,
+			title, COALESCE(body, ''), updated_at
+		FROM notes
+		WHERE id = $1
+	`, noteID)
+	return err
+}
+
+// ensureBaselineRevision records the current content of notes created before
+// revisions existed, so their first edit can still be undone.
+func ensureBaselineRevision(tx *sql.Tx, noteID int) error {
+	var exists bool
+	err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM note_revisions WHERE note_id=$1)`, noteID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	return recordRevision(tx, noteID)
+}
+
+// SaveBody replaces the body of a note edited outside the REST handlers (the
+// live editor's checkpoints) and returns its new version. The caller holds
+// the row lock. A revision is only recorded when asked for, so frequent
+// checkpoints don't flood the history.
+func SaveBody(tx *sql.Tx, noteID int, body string, revision bool) (int, error) {
+	if err := ensureBaselineRevision(tx, noteID); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := tx.QueryRow(`
+		UPDATE notes SET body=$1, version=version+1, updated_at=$2
+		WHERE id=$3
+		RETURNING version
+	`, body, time.Now(), noteID).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	if revision {
+		if err := recordRevision(tx, noteID); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := events.PublishNote(tx, noteID, "note", noteID, events.Updated); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// ownsNote reports whether a live note belongs to the user
+func ownsNote(db *sql.DB, noteID string, userID int) (bool, error) {
+	var exists bool
+	err := db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM notes WHERE id=$1 AND user_id=$2 AND deleted_at IS NULL)
+	`, noteID, userID).Scan(&exists)
+	return exists, err
+}
+
+func getRevision(db *sql.DB, noteID string, rev int) (Revision, error) {
+	var r Revision
+	err := db.QueryRow(`
+		SELECT revision, title, COALESCE(body, ''), created_at
+		FROM note_revisions
+		WHERE note_id=$1 AND revision=$2
+	`, noteID, rev).Scan(&r.Revision, &r.Title, &r.Body, &r.CreatedAt)
+	return r, err
+}
+
+// ListRevisionsHandler - GET /notes/:id/revisions
+func ListRevisionsHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+		noteID := c.Param("id")
+
+		owned, err := ownsNote(db, noteID, userID)
+		if err != nil || !owned {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found or not owned by user"})
+			return
+		}
+
+		rows, err := db.Query(`
+			SELECT revision, title, COALESCE(body, ''), created_at
+			FROM note_revisions
+			WHERE note_id=$1
+			ORDER BY revision DESC
+		`, noteID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch revisions"})
+			return
+		}
+		defer rows.Close()
+
+		var revisions []Revision
+		for rows.Next() {
+			var r Revision
+			if err := rows.Scan(&r.Revision, &r.Title, &r.Body, &r.CreatedAt); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan revision"})
+				return
+			}
+			revisions = append(revisions, r)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"revisions": revisions})
+	}
+}
+
+// GetRevisionHandler - GET /notes/:id/revisions/:rev
+func GetRevisionHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+		noteID := c.Param("id")
+
+		rev, err := strconv.Atoi(c.Param("rev"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision"})
+			return
+		}
+
+		owned, err := ownsNote(db, noteID, userID)
+		if err != nil || !owned {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found or not owned by user"})
+			return
+		}
+
+		r, err := getRevision(db, noteID, rev)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch revision"})
+			return
+		}
+
+		c.JSON(http.StatusOK, r)
+	}
+}
+
+// DiffRevisionsHandler - GET /notes/:id/diff?from=1&to=2
+func DiffRevisionsHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+		noteID := c.Param("id")
+
+		from, errFrom := strconv.Atoi(c.Query("from"))
+		to, errTo := strconv.Atoi(c.Query("to"))
+		if errFrom != nil || errTo != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be revision numbers"})
+			return
+		}
+
+		owned, err := ownsNote(db, noteID, userID)
+		if err != nil || !owned {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found or not owned by user"})
+			return
+		}
+
+		oldRev, err := getRevision(db, noteID, from)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+			return
+		}
+		newRev, err := getRevision(db, noteID, to)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+			return
+		}
+
+		titleDiff, err := DiffLines(oldRev.Title, newRev.Title)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Too many lines changed to show a diff"})
+			return
+		}
+		bodyDiff, err := DiffLines(oldRev.Body, newRev.Body)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Too many lines changed to show a diff"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"from":  from,
+			"to":    to,
+			"title": titleDiff,
+			"body":  bodyDiff,
+		})
+	}
+}
+
+// RestoreRevisionHandler - POST /notes/:id/revisions/:rev/restore
+// The restore is itself recorded as a new revision, so it can be undone.
+func RestoreRevisionHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+		noteID := c.Param("id")
+
+		rev, err := strconv.Atoi(c.Param("rev"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore revision"})
+			return
+		}
+		defer tx.Rollback()
+
+		var lockedID int
+		err = tx.QueryRow(`
+			SELECT id FROM notes WHERE id=$1 AND user_id=$2 AND deleted_at IS NULL FOR UPDATE
+		`, noteID, userID).Scan(&lockedID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found or not owned by user"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore revision"})
+			return
+		}
+
+		res, err := tx.Exec(`
+			UPDATE notes
+			SET title = r.title, body = r.body, version = notes.version + 1, updated_at = $1
+			FROM note_revisions r
+			WHERE notes.id = $2 AND r.note_id = notes.id AND r.revision = $3
+		`, time.Now(), lockedID, rev)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore revision"})
+			return
+		}
+		rowsAffected, _ := res.RowsAffected()
+		if rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+			return
+		}
+
+		if err := recordRevision(tx, lockedID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore revision"})
+			return
+		}
+
+		if err := events.PublishNote(tx, lockedID, "note", lockedID, events.Updated); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore revision"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore revision"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Revision restored successfully", "revision": rev})
+	}
+}
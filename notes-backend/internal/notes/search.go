@@ -0,0 +1,55 @@
+package notes
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Snippet shows why a note matched a search. Its text is HTML-escaped and
+// hits are wrapped in <mark>, so it can be shown as HTML.
+type Snippet struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+// ts_headline copies the note text as is, tags included, so hits are marked
+// with private-use characters that are removed from the text beforehand,
+// and only turned into <mark> once the rest has been escaped.
+const (
+	markStart = "\uE000"
+	markStop  = "\uE001"
+
+	titleHeadlineOptions = "StartSel=" + markStart + ", StopSel=" + markStop + ", HighlightAll=true"
+	bodyHeadlineOptions  = "StartSel=" + markStart + ", StopSel=" + markStop + ", MaxFragments=2, MaxWords=35, MinWords=15"
+)
+
+var markReplacer = strings.NewReplacer(markStart, "<mark>", markStop, "</mark>")
+
+// escape makes the snippet safe to render as HTML
+func (s *Snippet) escape() {
+	s.Title = markReplacer.Replace(html.EscapeString(s.Title))
+	s.Body = markReplacer.Replace(html.EscapeString(s.Body))
+}
+
+// tsQuery parses the search text bound to placeholder $p the way a web
+// search box would: quoted phrases, "or" and -exclusions are understood.
+func tsQuery(p int) string {
+	return fmt.Sprintf("websearch_to_tsquery('english', $%d)", p)
+}
+
+// searchCondition matches notes against the search text bound to $p
+func searchCondition(p int) string {
+	return " AND search_vector @@ " + tsQuery(p)
+}
+
+// searchColumns selects the rank and highlighted snippets for the search
+// text bound to $p. Title hits carry weight A and body hits weight B, so
+// ts_rank scores title matches higher.
+func searchColumns(p int) string {
+	q := tsQuery(p)
+	return fmt.Sprintf(`, ts_rank(search_vector, %[1]s) AS rank,
+		ts_headline('english', translate(title, '%[4]s', ''), %[1]s, '%[2]s'),
+		ts_headline('english', translate(COALESCE(body, ''), '%[4]s', ''), %[1]s, '%[3]s')`,
+		q, titleHeadlineOptions, bodyHeadlineOptions, markStart+markStop)
+}
@@ -0,0 +1,18 @@
+package notes
+
+import "testing"
+
+func TestSnippetEscape(t *testing.T) {
+	s := Snippet{
+		Title: "<img src=x onerror=alert(1)> " + markStart + "plan" + markStop,
+		Body:  "a & b <mark>not ours</mark> " + markStart + "plan" + markStop + " \"done\"",
+	}
+	s.escape()
+
+	if want := "&lt;img src=x onerror=alert(1)&gt; <mark>plan</mark>"; s.Title != want {
+		t.Errorf("title = %q, want %q", s.Title, want)
+	}
+	if want := "a &amp; b &lt;mark&gt;not ours&lt;/mark&gt; <mark>plan</mark> &#34;done&#34;"; s.Body != want {
+		t.Errorf("body = %q, want %q", s.Body, want)
+	}
+}
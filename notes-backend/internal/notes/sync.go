@@ -0,0 +1,453 @@
+package notes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"notes-backend/internal/categories"
+	"notes-backend/internal/images"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultSyncLimit = 500
+	maxSyncLimit     = 1000
+	maxSyncMutations = 500
+)
+
+// syncDeleted lists things that are gone, or no longer visible to the user
+type syncDeleted struct {
+	Notes      []int64 `json:"notes"`
+	Categories []int64 `json:"categories"`
+	Images     []int64 `json:"images"`
+}
+
+// GetSyncHandler - GET /sync?since=<token>&limit=500
+// Returns what changed for the user since a sync token: notes (trashed ones
+// carry deleted_at), categories and images in their current state, plus the
+// ids of those that are gone. The token is the position in the user's event
+// log. Without since, or with a token older than the log keeps, the whole
+// account is returned with "reset": true. Keep calling with the returned
+// token while has_more is true.
+func GetSyncHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		var since int64
+		if raw := c.Query("since"); raw != "" {
+			var err error
+			since, err = strconv.ParseInt(raw, 10, 64)
+			if err != nil || since < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sync token"})
+				return
+			}
+		}
+
+		limit := defaultSyncLimit
+		if raw := c.Query("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > maxSyncLimit {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxSyncLimit)})
+				return
+			}
+			limit = n
+		}
+
+		// One snapshot, so the token matches the rows returned with it
+		tx, err := db.BeginTx(c.Request.Context(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync"})
+			return
+		}
+		defer tx.Rollback()
+
+		reset := c.Query("since") == ""
+		if !reset {
+			// Has the log been pruned past the token?
+			var oldest int64
+			err := tx.QueryRow(`
+				SELECT COALESCE(
+					(SELECT MIN(id) FROM events),
+					(SELECT CASE WHEN is_called THEN last_value + 1 ELSE last_value END FROM events_id_seq)
+				)
+			`).Scan(&oldest)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync"})
+				return
+			}
+			reset = since+1 < oldest
+		}
+
+		var token int64
+		hasMore := false
+		// nil means everything
+		var noteIDs, categoryIDs, imageIDs []int64
+
+		if reset {
+			err = tx.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM events WHERE user_id=$1`, userID).Scan(&token)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync"})
+				return
+			}
+		} else {
+			token = since
+			noteIDs, categoryIDs, imageIDs = []int64{}, []int64{}, []int64{}
+
+			rows, err := tx.Query(`
+				SELECT id, entity, entity_id FROM events
+				WHERE user_id=$1 AND id > $2
+				ORDER BY id
+				LIMIT $3
+			`, userID, since, limit+1)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync"})
+				return
+			}
+			seen := map[string]bool{}
+			n := 0
+			for rows.Next() {
+				var id, entityID int64
+				var entity string
+				if err := rows.Scan(&id, &entity, &entityID); err != nil {
+					rows.Close()
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync"})
+					return
+				}
+				if n++; n > limit {
+					hasMore = true
+					break
+				}
+				token = id
+
+				key := entity + ":" + strconv.FormatInt(entityID, 10)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				switch entity {
+				case "note":
+					noteIDs = append(noteIDs, entityID)
+				case "category":
+					categoryIDs = append(categoryIDs, entityID)
+				case "image":
+					imageIDs = append(imageIDs, entityID)
+				}
+			}
+			rows.Close()
+		}
+
+		notes, err := syncNotes(tx, userID, noteIDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync"})
+			return
+		}
+		cats, err := syncCategories(tx, userID, categoryIDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync"})
+			return
+		}
+		imgs, err := syncImages(tx, userID, imageIDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync"})
+			return
+		}
+
+		deleted := syncDeleted{Notes: []int64{}, Categories: []int64{}, Images: []int64{}}
+		if !reset {
+			found := map[int64]bool{}
+			for _, n := range notes {
+				found[int64(n.ID)] = true
+			}
+			deleted.Notes = missing(noteIDs, found)
+
+			found = map[int64]bool{}
+			for _, cat := range cats {
+				found[int64(cat.ID)] = true
+			}
+			deleted.Categories = missing(categoryIDs, found)
+
+			found = map[int64]bool{}
+			for _, img := range imgs {
+				found[int64(img.ID)] = true
+			}
+			deleted.Images = missing(imageIDs, found)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"token":      strconv.FormatInt(token, 10),
+			"has_more":   hasMore,
+			"reset":      reset,
+			"notes":      notes,
+			"categories": cats,
+			"images":     imgs,
+			"deleted":    deleted,
+		})
+	}
+}
+
+func missing(ids []int64, found map[int64]bool) []int64 {
+	out := []int64{}
+	for _, id := range ids {
+		if !found[id] {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// syncNotes loads the user's notes, trashed ones included, and the live
+// notes shared with them. ids narrows it down; nil loads all of them.
+func syncNotes(tx *sql.Tx, userID int, ids []int64) ([]Note, error) {
+	rows, err := tx.Query(`
+		SELECT notes.id, notes.title, notes.body, notes.category_id, notes.is_favorite, notes.visibility,
+			notes.public_slug, notes.version, notes.created_at, notes.updated_at, notes.deleted_at, `+tagsColumn+`,
+			CASE WHEN notes.user_id = $1 THEN 'owner' ELSE s.role END
+		FROM notes
+		LEFT JOIN note_shares s ON s.note_id = notes.id AND s.user_id = $1
+		WHERE (notes.user_id = $1 OR (s.user_id IS NOT NULL AND notes.deleted_at IS NULL AND notes.visibility <> 'private'))
+		AND ($2::int[] IS NULL OR notes.id = ANY($2))
+		ORDER BY notes.id
+	`, userID, pq.Int64Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []Note{}
+	for rows.Next() {
+		var n Note
+		var categoryID sql.NullInt64
+		err := rows.Scan(&n.ID, &n.Title, &n.Body, &categoryID, &n.IsFavorite, &n.Visibility,
+			&n.PublicSlug, &n.Version, &n.CreatedAt, &n.UpdatedAt, &n.DeletedAt, pq.Array(&n.Tags), &n.Role)
+		if err != nil {
+			return nil, err
+		}
+		if categoryID.Valid {
+			id := int(categoryID.Int64)
+			n.CategoryID = &id
+		}
+		notes = append(notes, n)
+	}
+	return notes, rows.Err()
+}
+
+func syncCategories(tx *sql.Tx, userID int, ids []int64) ([]categories.Category, error) {
+	rows, err := tx.Query(`
+		SELECT id, user_id, name, created_at FROM categories
+		WHERE user_id = $1 AND ($2::int[] IS NULL OR id = ANY($2))
+		ORDER BY id
+	`, userID, pq.Int64Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cats := []categories.Category{}
+	for rows.Next() {
+		var cat categories.Category
+		if err := rows.Scan(&cat.ID, &cat.UserID, &cat.Name, &cat.CreatedAt); err != nil {
+			return nil, err
+		}
+		cats = append(cats, cat)
+	}
+	return cats, rows.Err()
+}
+
+// syncImages loads the images of the notes syncNotes would return
+func syncImages(tx *sql.Tx, userID int, ids []int64) ([]images.Image, error) {
+	rows, err := tx.Query(`
+		SELECT i.id, i.note_id, i.url, i.created_at
+		FROM images i
+		JOIN notes ON notes.id = i.note_id
+		LEFT JOIN note_shares s ON s.note_id = notes.id AND s.user_id = $1
+		WHERE (notes.user_id = $1 OR (s.user_id IS NOT NULL AND notes.deleted_at IS NULL AND notes.visibility <> 'private'))
+		AND ($2::int[] IS NULL OR i.id = ANY($2))
+		ORDER BY i.id
+	`, userID, pq.Int64Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	imgs := []images.Image{}
+	for rows.Next() {
+		var img images.Image
+		if err := rows.Scan(&img.ID, &img.NoteID, &img.URL, &img.CreatedAt); err != nil {
+			return nil, err
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs, rows.Err()
+}
+
+// Mutation is one change a client made while offline
+type Mutation struct {
+	ClientID    string          `json:"client_id"` // echoed back, e.g. to map a temporary id
+	Entity      string          `json:"entity"`    // "note" or "category"
+	Action      string          `json:"action"`    // "create", "update" or "delete"
+	ID          int             `json:"id"`
+	BaseVersion *int            `json:"base_version"` // note version the change was made on
+	Data        json.RawMessage `json:"data"`         // NoteRequest, UpdateNoteRequest or {"name": ...}
+}
+
+// MutationResult says what happened to one mutation
+type MutationResult struct {
+	ClientID string `json:"client_id,omitempty"`
+	Status   string `json:"status"` // "applied", "conflict" or "error"
+	ID       int    `json:"id,omitempty"`
+	Version  int    `json:"version,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Current  *Note  `json:"current,omitempty"` // the server's copy on conflict
+}
+
+// ApplySyncHandler - POST /sync
+// Applies a batch of offline mutations in order, each in its own
+// transaction, and reports a result per item. Note updates and deletes with
+// a base_version that is no longer current are conflicts and come back with
+// the server's copy; without base_version the last write wins. Clients then
+// GET /sync to pick up everything else.
+func ApplySyncHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		var input struct {
+			Mutations []Mutation `json:"mutations" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		if len(input.Mutations) > maxSyncMutations {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "At most " + strconv.Itoa(maxSyncMutations) + " mutations per request"})
+			return
+		}
+
+		results := make([]MutationResult, len(input.Mutations))
+		for i, m := range input.Mutations {
+			results[i] = applyMutation(db, userID, m)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"results": results})
+	}
+}
+
+func applyMutation(db *sql.DB, userID int, m Mutation) MutationResult {
+	res := MutationResult{ClientID: m.ClientID}
+
+	tx, err := db.Begin()
+	if err != nil {
+		res.Status, res.Error = "error", "Failed to apply change"
+		return res
+	}
+	defer tx.Rollback()
+
+	err = applyMutationTx(tx, userID, m, &res)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		res.ID, res.Version = 0, 0
+		if ne, ok := err.(*NoteError); ok {
+			res.Error = ne.Message
+			res.Status = "error"
+			if ne.Status == http.StatusPreconditionFailed {
+				res.Status = "conflict"
+				res.Current = ne.Current
+			}
+		} else {
+			res.Status, res.Error = "error", "Failed to apply change"
+		}
+		return res
+	}
+
+	res.Status = "applied"
+	return res
+}
+
+func applyMutationTx(tx *sql.Tx, userID int, m Mutation, res *MutationResult) error {
+	var matches func(Note) bool
+	if m.BaseVersion != nil {
+		base := *m.BaseVersion
+		matches = func(current Note) bool { return current.Version == base }
+	}
+
+	switch m.Entity + "." + m.Action {
+	case "note.create":
+		var req NoteRequest
+		if err := json.Unmarshal(m.Data, &req); err != nil || req.Title == "" {
+			return &NoteError{Status: http.StatusBadRequest, Message: "Invalid note"}
+		}
+		if req.Visibility == "" {
+			req.Visibility = "private"
+		}
+		now := time.Now()
+		id, err := InsertNote(tx, userID, req, now, now)
+		if err != nil {
+			return err
+		}
+		res.ID, res.Version = id, 1
+		return nil
+
+	case "note.update":
+		var req UpdateNoteRequest
+		if err := json.Unmarshal(m.Data, &req); err != nil {
+			return &NoteError{Status: http.StatusBadRequest, Message: "Invalid note"}
+		}
+		id, version, err := UpdateNote(tx, userID, m.ID, req, matches)
+		if err != nil {
+			return err
+		}
+		res.ID, res.Version = id, version
+		return nil
+
+	case "note.delete":
+		err := DeleteNote(tx, userID, m.ID, matches)
+		if ne, ok := err.(*NoteError); ok && ne.Status == http.StatusNotFound {
+			// Already in the trash counts as done, so retries are harmless
+			var trashed bool
+			if err := tx.QueryRow(`
+				SELECT EXISTS(SELECT 1 FROM notes WHERE id=$1 AND user_id=$2 AND deleted_at IS NOT NULL)
+			`, m.ID, userID).Scan(&trashed); err != nil {
+				return err
+			}
+			if trashed {
+				res.ID = m.ID
+				return nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+		res.ID = m.ID
+		return nil
+
+	case "category.create":
+		var input struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(m.Data, &input); err != nil || input.Name == "" {
+			return &NoteError{Status: http.StatusBadRequest, Message: "Invalid category"}
+		}
+		id, err := categories.CreateCategory(tx, userID, input.Name)
+		if err != nil {
+			return err
+		}
+		res.ID = id
+		return nil
+
+	case "category.delete":
+		// Deleting a category that is already gone is not an error
+		if _, err := categories.DeleteCategory(tx, userID, m.ID); err != nil {
+			return err
+		}
+		res.ID = m.ID
+		return nil
+	}
+
+	return &NoteError{Status: http.StatusBadRequest, Message: "Unknown entity or action"}
+}
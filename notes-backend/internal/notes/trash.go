@@ -0,0 +1,195 @@
+package notes
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"notes-backend/internal/events"
+	"notes-backend/internal/images"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// ListTrashHandler - GET /notes/trash
+func ListTrashHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		rows, err := db.Query(`
+			SELECT id, title, body, category_id, is_favorite, visibility, version, created_at, updated_at, deleted_at, `+tagsColumn+`
+			FROM notes
+			WHERE user_id = $1 AND deleted_at IS NOT NULL
+			ORDER BY deleted_at DESC
+		`, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trash"})
+			return
+		}
+		defer rows.Close()
+
+		var notes []Note
+		for rows.Next() {
+			var n Note
+			var category sql.NullInt64
+			var deletedAt time.Time
+
+			if err := rows.Scan(&n.ID, &n.Title, &n.Body, &category, &n.IsFavorite, &n.Visibility, &n.Version, &n.CreatedAt, &n.UpdatedAt, &deletedAt, pq.Array(&n.Tags)); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan note"})
+				return
+			}
+
+			if category.Valid {
+				id := int(category.Int64)
+				n.CategoryID = &id
+			}
+			n.DeletedAt = &deletedAt
+
+			notes = append(notes, n)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"notes": notes})
+	}
+}
+
+// RestoreNoteHandler - POST /notes/:id/restore
+func RestoreNoteHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+		noteID := c.Param("id")
+
+		var id int
+		err := db.QueryRow(`
+			UPDATE notes
+			SET deleted_at=NULL, version=version+1, updated_at=$1
+			WHERE id=$2 AND user_id=$3 AND deleted_at IS NOT NULL
+			RETURNING id
+		`, time.Now(), noteID, userID).Scan(&id)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found in trash"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore note"})
+			return
+		}
+
+		events.LogError(events.PublishNote(db, id, "note", id, events.Restored))
+
+		c.JSON(http.StatusOK, gin.H{"message": "Note restored successfully"})
+	}
+}
+
+// PurgeNoteHandler - DELETE /notes/:id/purge
+// Only notes already in the trash can be purged.
+func PurgeNoteHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+		noteID := c.Param("id")
+
+		var id int
+		err := db.QueryRow(`
+			SELECT id FROM notes WHERE id=$1 AND user_id=$2 AND deleted_at IS NOT NULL
+		`, noteID, userID).Scan(&id)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found in trash"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge note"})
+			return
+		}
+
+		if err := purgeNote(db, id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge note"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Note permanently deleted"})
+	}
+}
+
+// purgeNote permanently deletes a note, its image rows and their files.
+// Revisions and tag links go with the note through ON DELETE CASCADE.
+func purgeNote(db *sql.DB, noteID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`DELETE FROM images WHERE note_id=$1 RETURNING url`, noteID)
+	if err != nil {
+		return err
+	}
+	var urls []string
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			rows.Close()
+			return err
+		}
+		urls = append(urls, url)
+	}
+	rows.Close()
+
+	// Published before the delete, while the owner can still be looked up
+	if err := events.PublishNote(tx, noteID, "note", noteID, events.Deleted); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM notes WHERE id=$1`, noteID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Files are removed only once the rows are gone for good
+	if err := images.RemoveFiles(urls); err != nil {
+		fmt.Printf("❌ Failed to remove files for note %d: %v\n", noteID, err)
+	}
+	return nil
+}
+
+// StartTrashSweeper purges notes that have been in the trash longer than
+// retention, checking every interval. It runs until the process exits.
+func StartTrashSweeper(db *sql.DB, retention, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			sweepTrash(db, retention)
+			<-ticker.C
+		}
+	}()
+}
+
+func sweepTrash(db *sql.DB, retention time.Duration) {
+	rows, err := db.Query(`
+		SELECT id FROM notes WHERE deleted_at IS NOT NULL AND deleted_at < $1
+	`, time.Now().Add(-retention))
+	if err != nil {
+		fmt.Printf("❌ Trash sweep failed: %v\n", err)
+		return
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := purgeNote(db, id); err != nil {
+			fmt.Printf("❌ Failed to purge note %d: %v\n", id, err)
+		}
+	}
+	if len(ids) > 0 {
+		fmt.Printf("Trash sweep purged %d note(s)\n", len(ids))
+	}
+}
@@ -0,0 +1,129 @@
+package public
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"notes-backend/internal/images"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PublicImage struct {
+	ID  int    `json:"id"`
+	URL string `json:"url"`
+}
+
+type PublicNote struct {
+	Title     string        `json:"title"`
+	Body      string        `json:"body"`
+	Author    string        `json:"author"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	Images    []PublicImage `json:"images"`
+}
+
+var pageTemplate = template.Must(template.New("note").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 720px; margin: 2rem auto; padding: 0 1rem; color: #222; }
+.meta { color: #777; font-size: 0.9rem; }
+.body { white-space: pre-wrap; line-height: 1.5; }
+img { max-width: 100%; margin-top: 1rem; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="meta">by {{.Author}} · updated {{.UpdatedAt.Format "2 Jan 2006"}}</p>
+<div class="body">{{.Body}}</div>
+{{range .Images}}<img src="{{.URL}}" alt="">
+{{end}}
+</body>
+</html>
+`))
+
+// GetPublicNoteHandler - GET /p/:slug
+// Serves JSON by default and an HTML page when the client asks for text/html
+// (or passes ?format=html).
+func GetPublicNoteHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		slug := c.Param("slug")
+
+		var noteID int
+		var n PublicNote
+		var body sql.NullString
+		err := db.QueryRow(`
+			SELECT n.id, n.title, n.body, u.username, n.created_at, n.updated_at
+			FROM notes n
+			JOIN users u ON u.id = n.user_id
+			WHERE n.public_slug = $1 AND n.visibility = 'public' AND n.deleted_at IS NULL
+		`, slug).Scan(&noteID, &n.Title, &body, &n.Author, &n.CreatedAt, &n.UpdatedAt)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch note"})
+			return
+		}
+		n.Body = body.String
+
+		rows, err := db.Query(`SELECT id FROM images WHERE note_id=$1 ORDER BY created_at`, noteID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+			return
+		}
+		defer rows.Close()
+
+		n.Images = []PublicImage{}
+		for rows.Next() {
+			var img PublicImage
+			if err := rows.Scan(&img.ID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning image"})
+				return
+			}
+			img.URL = fmt.Sprintf("/p/%s/images/%d", slug, img.ID)
+			n.Images = append(n.Images, img)
+		}
+
+		if c.Query("format") == "html" || c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML {
+			c.Header("Content-Type", "text/html; charset=utf-8")
+			c.Status(http.StatusOK)
+			if err := pageTemplate.Execute(c.Writer, n); err != nil {
+				fmt.Printf("❌ Failed to render public note: %v\n", err)
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, n)
+	}
+}
+
+// GetPublicImageHandler - GET /p/:slug/images/:image_id
+// Only images of the public note are served, never the rest of uploads/.
+func GetPublicImageHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		slug := c.Param("slug")
+		imageID := c.Param("image_id")
+
+		var url string
+		err := db.QueryRow(`
+			SELECT i.url
+			FROM images i
+			JOIN notes n ON n.id = i.note_id
+			WHERE i.id = $1 AND n.public_slug = $2 AND n.visibility = 'public' AND n.deleted_at IS NULL
+		`, imageID, slug).Scan(&url)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+
+		c.File(images.LocalPath(url))
+	}
+}
@@ -0,0 +1,208 @@
+package sharing
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"notes-backend/internal/events"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles a user can have on a note
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// Queryer is satisfied by both *sql.DB and *sql.Tx
+type Queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// NoteRole returns the role of a user on a live note, or sql.ErrNoRows if
+// the user has no access. Grantees only get access while the note is not
+// private, so switching a note back to private suspends its shares.
+func NoteRole(q Queryer, noteID interface{}, userID int) (string, error) {
+	var role string
+	err := q.QueryRow(`
+		SELECT CASE WHEN n.user_id = $2 THEN 'owner' ELSE s.role END
+		FROM notes n
+		LEFT JOIN note_shares s ON s.note_id = n.id AND s.user_id = $2
+		WHERE n.id = $1 AND n.deleted_at IS NULL
+		AND (n.user_id = $2 OR (s.user_id IS NOT NULL AND n.visibility <> 'private'))
+	`, noteID, userID).Scan(&role)
+	return role, err
+}
+
+// CanEdit reports whether a role may change a note's content and images
+func CanEdit(role string) bool {
+	return role == RoleOwner || role == RoleEditor
+}
+
+type Share struct {
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ownsNote checks that the caller owns the live note
+func ownsNote(db *sql.DB, noteID string, userID int) bool {
+	var exists bool
+	err := db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM notes WHERE id=$1 AND user_id=$2 AND deleted_at IS NULL)
+	`, noteID, userID).Scan(&exists)
+	return err == nil && exists
+}
+
+// GrantShareHandler - POST /notes/:id/shares
+// Granting again updates the role. A private note becomes 'shared'.
+func GrantShareHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+		noteID := c.Param("id")
+
+		var input struct {
+			Username string `json:"username" binding:"required"`
+			Role     string `json:"role"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		if input.Role == "" {
+			input.Role = RoleViewer
+		}
+		if input.Role != RoleViewer && input.Role != RoleEditor {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "role must be 'viewer' or 'editor'"})
+			return
+		}
+
+		if !ownsNote(db, noteID, userID) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found or not owned by user"})
+			return
+		}
+
+		var granteeID int
+		err := db.QueryRow("SELECT id FROM users WHERE username=$1", input.Username).Scan(&granteeID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if granteeID == userID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot share a note with yourself"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share note"})
+			return
+		}
+		defer tx.Rollback()
+
+		_, err = tx.Exec(`
+			INSERT INTO note_shares (note_id, user_id, role)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (note_id, user_id) DO UPDATE SET role = EXCLUDED.role
+		`, noteID, granteeID, input.Role)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share note"})
+			return
+		}
+
+		_, err = tx.Exec(`
+			UPDATE notes SET visibility='shared', version=version+1, updated_at=$1
+			WHERE id=$2 AND visibility='private'
+		`, time.Now(), noteID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share note"})
+			return
+		}
+
+		// The grantee gets the note as an update too
+		if err := events.PublishNote(tx, noteID, "note", noteID, events.Updated); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share note"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share note"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Note shared successfully", "user_id": granteeID, "role": input.Role})
+	}
+}
+
+// ListSharesHandler - GET /notes/:id/shares
+func ListSharesHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+		noteID := c.Param("id")
+
+		if !ownsNote(db, noteID, userID) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found or not owned by user"})
+			return
+		}
+
+		rows, err := db.Query(`
+			SELECT s.user_id, u.username, s.role, s.created_at
+			FROM note_shares s
+			JOIN users u ON u.id = s.user_id
+			WHERE s.note_id = $1
+			ORDER BY u.username
+		`, noteID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shares"})
+			return
+		}
+		defer rows.Close()
+
+		var shares []Share
+		for rows.Next() {
+			var s Share
+			if err := rows.Scan(&s.UserID, &s.Username, &s.Role, &s.CreatedAt); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan share"})
+				return
+			}
+			shares = append(shares, s)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"shares": shares})
+	}
+}
+
+// RevokeShareHandler - DELETE /notes/:id/shares/:user_id
+func RevokeShareHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+		noteID := c.Param("id")
+		granteeID := c.Param("user_id")
+
+		if !ownsNote(db, noteID, userID) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found or not owned by user"})
+			return
+		}
+
+		var revokedID int
+		err := db.QueryRow(`
+			DELETE FROM note_shares WHERE note_id=$1 AND user_id=$2 RETURNING user_id
+		`, noteID, granteeID).Scan(&revokedID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Share not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share"})
+			return
+		}
+
+		// For the former grantee the note is gone
+		events.LogError(events.Publish(db, revokedID, "note", noteID, events.Deleted))
+
+		c.JSON(http.StatusOK, gin.H{"message": "Share revoked successfully"})
+	}
+}
@@ -0,0 +1,284 @@
+package tags
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"notes-backend/internal/events"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+type Tag struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	NoteCount int    `json:"note_count"`
+	CreatedAt string `json:"created_at"`
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint error
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// NormalizeNames trims tag names and drops blanks and duplicates
+func NormalizeNames(names []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		out = append(out, name)
+	}
+	return out
+}
+
+// SetNoteTags replaces the tags of a note with the given names,
+// creating any tag the user does not have yet.
+func SetNoteTags(tx *sql.Tx, userID, noteID int, names []string) error {
+	names = NormalizeNames(names)
+
+	if len(names) > 0 {
+		_, err := tx.Exec(`
+			INSERT INTO tags (user_id, name)
+			SELECT $1, unnest($2::text[])
+			ON CONFLICT (user_id, name) DO NOTHING
+		`, userID, pq.Array(names))
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM note_tags WHERE note_id=$1`, noteID); err != nil {
+		return err
+	}
+
+	if len(names) > 0 {
+		_, err := tx.Exec(`
+			INSERT INTO note_tags (note_id, tag_id)
+			SELECT $1, id FROM tags WHERE user_id=$2 AND name = ANY($3)
+		`, noteID, userID, pq.Array(names))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateTagHandler - POST /tags
+func CreateTagHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Name string `json:"name"`
+		}
+		if err := c.BindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		name := strings.TrimSpace(input.Name)
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Tag name is required"})
+			return
+		}
+
+		userID := c.GetInt("userID")
+
+		var id int
+		err := db.QueryRow(
+			"INSERT INTO tags (user_id, name) VALUES ($1, $2) RETURNING id",
+			userID, name,
+		).Scan(&id)
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Tag already exists"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tag"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"id": id, "name": name})
+	}
+}
+
+// ListTagsHandler - GET /tags
+func ListTagsHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		rows, err := db.Query(`
+			SELECT t.id, t.name, t.created_at, COUNT(n.id)
+			FROM tags t
+			LEFT JOIN note_tags nt ON nt.tag_id = t.id
+			LEFT JOIN notes n ON n.id = nt.note_id AND n.deleted_at IS NULL
+			WHERE t.user_id = $1
+			GROUP BY t.id
+			ORDER BY t.name
+		`, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags"})
+			return
+		}
+		defer rows.Close()
+
+		var tags []Tag
+		for rows.Next() {
+			var t Tag
+			if err := rows.Scan(&t.ID, &t.Name, &t.CreatedAt, &t.NoteCount); err != nil {
+				continue
+			}
+			tags = append(tags, t)
+		}
+
+		c.JSON(http.StatusOK, tags)
+	}
+}
+
+// RenameTagHandler - PATCH /tags/:id
+func RenameTagHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Name string `json:"name"`
+		}
+		if err := c.BindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		name := strings.TrimSpace(input.Name)
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Tag name is required"})
+			return
+		}
+
+		userID := c.GetInt("userID")
+		tagID := c.Param("id")
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename tag"})
+			return
+		}
+		defer tx.Rollback()
+
+		res, err := tx.Exec(`
+			UPDATE tags SET name=$1
+			WHERE id=$2 AND user_id=$3
+		`, name, tagID, userID)
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Tag already exists"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename tag"})
+			return
+		}
+
+		rowsAffected, _ := res.RowsAffected()
+		if rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found or not owned by user"})
+			return
+		}
+
+		noteIDs, err := tagNoteIDs(tx, tagID)
+		if err == nil {
+			err = publishNotes(tx, noteIDs)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename tag"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename tag"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Tag renamed successfully"})
+	}
+}
+
+// DeleteTagHandler - DELETE /tags/:id
+// The tag is removed from its notes through ON DELETE CASCADE on note_tags.
+func DeleteTagHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+		tagID := c.Param("id")
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tag"})
+			return
+		}
+		defer tx.Rollback()
+
+		// Collected before the cascade removes the links
+		noteIDs, err := tagNoteIDs(tx, tagID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tag"})
+			return
+		}
+
+		res, err := tx.Exec(`
+			DELETE FROM tags
+			WHERE id=$1 AND user_id=$2
+		`, tagID, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tag"})
+			return
+		}
+
+		rowsAffected, _ := res.RowsAffected()
+		if rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found or not owned by user"})
+			return
+		}
+
+		if err := publishNotes(tx, noteIDs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tag"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tag"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Tag deleted successfully"})
+	}
+}
+
+// tagNoteIDs lists the notes carrying a tag
+func tagNoteIDs(tx *sql.Tx, tagID string) ([]int, error) {
+	rows, err := tx.Query(`SELECT note_id FROM note_tags WHERE tag_id=$1`, tagID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// publishNotes reports notes whose tag list changed
+func publishNotes(tx *sql.Tx, noteIDs []int) error {
+	for _, id := range noteIDs {
+		if err := events.PublishNote(tx, id, "note", id, events.Updated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
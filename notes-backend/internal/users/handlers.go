@@ -0,0 +1,454 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"notes-backend/internal/auth"
+	"notes-backend/internal/events"
+	"notes-backend/internal/images"
+	"notes-backend/internal/mailer"
+	"notes-backend/internal/markdown"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Archives of deleted accounts are kept this long for download
+const (
+	exportDir = "exports"
+	exportTTL = 24 * time.Hour
+)
+
+// isUniqueViolation reports whether err is a Postgres unique constraint error
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func getUser(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, userID int) (User, error) {
+	var u User
+	err := q.QueryRow(`
+		SELECT id, username, email, email_verified_at, password_hash, role, totp_enabled, created_at
+		FROM users WHERE id=$1
+	`, userID).Scan(&u.ID, &u.Username, &u.Email, &u.EmailVerifiedAt, &u.PasswordHash, &u.Role, &u.TwoFactorEnabled, &u.CreatedAt)
+	u.HasPassword = u.PasswordHash != ""
+	return u, err
+}
+
+// GetMeHandler - GET /me
+func GetMeHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, err := getUser(db, c.GetInt("userID"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+			return
+		}
+		c.JSON(http.StatusOK, u)
+	}
+}
+
+// UpdateMeHandler - PATCH /me
+// A new email address needs the current password, and only replaces the
+// old one once the link mailed to it is opened.
+func UpdateMeHandler(db *sql.DB, m mailer.Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		var req struct {
+			Username        *string `json:"username"`
+			Email           *string `json:"email"`
+			CurrentPassword string  `json:"current_password"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		u, err := getUser(db, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+			return
+		}
+
+		if req.Username != nil {
+			username := strings.TrimSpace(*req.Username)
+			if username == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Username cannot be empty"})
+				return
+			}
+			u.Username = username
+		}
+		if req.Email != nil {
+			email, err := auth.NormalizeEmail(*req.Email)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+				return
+			}
+			if !strings.EqualFold(email, u.Email) {
+				if u.HasPassword && bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(req.CurrentPassword)) != nil {
+					c.JSON(http.StatusUnauthorized, gin.H{"error": "current_password is required to change the email address"})
+					return
+				}
+				var taken bool
+				if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE lower(email)=$1)`, email).Scan(&taken); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+					return
+				}
+				if taken {
+					c.JSON(http.StatusConflict, gin.H{"error": "Email is already taken"})
+					return
+				}
+				u.PendingEmail = email
+			}
+		}
+
+		_, err = db.Exec(`UPDATE users SET username=$1 WHERE id=$2`, u.Username, userID)
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Username is already taken"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+			return
+		}
+
+		if u.PendingEmail != "" {
+			if err := auth.SendEmailChangeEmail(db, m, userID, u.Email, u.PendingEmail); err != nil {
+				fmt.Printf("❌ Failed to send email change link: %v\n", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send confirmation to the new address"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, u)
+	}
+}
+
+// ChangePasswordHandler - POST /me/password
+// Ends every other session and returns fresh tokens for this one.
+func ChangePasswordHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		var req struct {
+			CurrentPassword string `json:"current_password" binding:"required"`
+			NewPassword     string `json:"new_password" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "current_password and new_password are required"})
+			return
+		}
+		if err := auth.ValidatePassword(req.NewPassword); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		var hash string
+		if err := tx.QueryRow(`SELECT password_hash FROM users WHERE id=$1 FOR UPDATE`, userID).Scan(&hash); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+			return
+		}
+		if hash == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This account has no password yet, set one with a password reset"})
+			return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.CurrentPassword)) != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+			return
+		}
+
+		newHash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+		if _, err := tx.Exec(`UPDATE users SET password_hash=$1 WHERE id=$2`, string(newHash), userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+			return
+		}
+		if err := auth.RevokeAllSessions(tx, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+			return
+		}
+
+		pair, err := auth.IssueTokens(db, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Password changed, but failed to generate token"})
+			return
+		}
+		c.JSON(http.StatusOK, pair)
+	}
+}
+
+// DeleteMeHandler - DELETE /me
+// Writes an archive of all notes, categories and images first, then
+// deletes the account and its upload files. The archive can be downloaded
+// from the returned URL for the next 24 hours.
+func DeleteMeHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		// Accounts without a password confirm with their username
+		var req struct {
+			Password string `json:"password"`
+			Confirm  string `json:"confirm"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		u, err := getUser(db, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+			return
+		}
+		if u.HasPassword {
+			if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(req.Password)) != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Password is incorrect"})
+				return
+			}
+		} else if req.Confirm != u.Username {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Send your username as confirm to delete the account"})
+			return
+		}
+
+		expiresAt := time.Now().Add(exportTTL)
+		token, files, err := deleteUser(db, userID, expiresAt)
+		if err != nil {
+			fmt.Printf("❌ Failed to delete account %d: %v\n", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account, nothing was deleted"})
+			return
+		}
+
+		// Files are removed only once the rows are gone for good
+		for _, f := range files {
+			if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+				fmt.Printf("❌ Failed to remove files of user %d: %v\n", userID, err)
+			}
+		}
+
+		// The token stays out of URLs, which end up in logs and histories
+		c.JSON(http.StatusOK, gin.H{
+			"message":      "Account deleted",
+			"export_token": token,
+			"expires_at":   expiresAt,
+		})
+	}
+}
+
+// writeExport writes the account archive to a file and returns the token
+// to download it with.
+func writeExport(tx *sql.Tx, userID int) (string, string, error) {
+	token, err := auth.RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.MkdirAll(exportDir, 0700); err != nil {
+		return "", "", err
+	}
+	f, err := os.CreateTemp(exportDir, "account-*.zip")
+	if err != nil {
+		return "", "", err
+	}
+	if err := markdown.WriteAccountArchive(tx, userID, f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", "", err
+	}
+	return token, f.Name(), nil
+}
+
+// deleteUser exports the account and removes it with everything cascading
+// from it, in one transaction that first locks the user's rows, so nothing
+// can be written in between that the archive would miss. It returns the
+// export token and the files that have to go too: the images and any
+// import uploads still waiting to be processed.
+func deleteUser(db *sql.DB, userID int, expiresAt time.Time) (string, []string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", nil, err
+	}
+	defer tx.Rollback()
+
+	// Locking the user and its notes and categories makes inserts that
+	// reference them, and updates to them, wait for the deletion
+	for _, lock := range []string{
+		`SELECT id FROM users WHERE id=$1 FOR UPDATE`,
+		`SELECT id FROM notes WHERE user_id=$1 FOR UPDATE`,
+		`SELECT id FROM categories WHERE user_id=$1 FOR UPDATE`,
+	} {
+		if _, err := tx.Exec(lock, userID); err != nil {
+			return "", nil, err
+		}
+	}
+
+	token, path, err := writeExport(tx, userID)
+	if err != nil {
+		return "", nil, fmt.Errorf("exporting: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			os.Remove(path)
+		}
+	}()
+
+	files, err := queryStrings(tx, `
+		SELECT i.url FROM images i JOIN notes n ON n.id = i.note_id WHERE n.user_id=$1
+	`, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	for i, url := range files {
+		files[i] = images.LocalPath(url)
+	}
+	uploads, err := queryStrings(tx, `
+		SELECT upload_path FROM import_jobs WHERE user_id=$1 AND upload_path IS NOT NULL
+	`, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	files = append(files, uploads...)
+
+	// People the notes were shared with see them disappear
+	var shared []int
+	rows, err := tx.Query(`
+		SELECT DISTINCT n.id FROM notes n JOIN note_shares s ON s.note_id = n.id WHERE n.user_id=$1
+	`, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	for rows.Next() {
+		var noteID int
+		if err := rows.Scan(&noteID); err != nil {
+			rows.Close()
+			return "", nil, err
+		}
+		shared = append(shared, noteID)
+	}
+	rows.Close()
+	for _, noteID := range shared {
+		if err := events.PublishNote(tx, noteID, "note", noteID, events.Deleted); err != nil {
+			return "", nil, err
+		}
+	}
+
+	// Access tokens outlive the rows, so deny them explicitly
+	if err := auth.RevokeAllSessions(tx, userID); err != nil {
+		return "", nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE id=$1`, userID); err != nil {
+		return "", nil, err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO account_exports (token_hash, path, expires_at) VALUES ($1, $2, $3)
+	`, auth.HashToken(token), path, expiresAt); err != nil {
+		return "", nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", nil, err
+	}
+	committed = true
+	return token, files, nil
+}
+
+// queryStrings returns the single text column of every row
+func queryStrings(tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// DownloadExportHandler - POST /account-exports/download
+// Public: the account is gone, so the token is the only credential. It is
+// taken from a JSON or form body, so a plain HTML form can download too.
+func DownloadExportHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Token string `json:"token" form:"token" binding:"required"`
+		}
+		if err := c.ShouldBind(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		var path string
+		err := db.QueryRow(`
+			SELECT path FROM account_exports WHERE token_hash=$1 AND expires_at > NOW()
+		`, auth.HashToken(req.Token)).Scan(&path)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Export not found or expired"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch export"})
+			return
+		}
+
+		c.FileAttachment(path, fmt.Sprintf("notes-account-%s.zip", time.Now().Format("2006-01-02")))
+	}
+}
+
+// StartExportSweeper deletes account archives once they expire
+func StartExportSweeper(db *sql.DB, interval time.Duration) {
+	go func() {
+		for {
+			if err := sweepExports(db); err != nil {
+				fmt.Printf("❌ Failed to sweep account exports: %v\n", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func sweepExports(db *sql.DB) error {
+	rows, err := db.Query(`DELETE FROM account_exports WHERE expires_at < NOW() RETURNING path`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("❌ Failed to remove %s: %v\n", path, err)
+		}
+	}
+	return rows.Err()
+}
@@ -3,8 +3,14 @@ package users
 import "time"
 
 type User struct {
-	ID           int       `db:"id" json:"id"`
-	Username     string    `db:"username" json:"username"`
-	PasswordHash string    `db:"password_hash" json:"-"`
-	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	ID               int        `db:"id" json:"id"`
+	Username         string     `db:"username" json:"username"`
+	Email            string     `db:"email" json:"email"`
+	EmailVerifiedAt  *time.Time `db:"email_verified_at" json:"email_verified_at"`
+	PasswordHash     string     `db:"password_hash" json:"-"`
+	Role             string     `db:"role" json:"role"` // "user" or "admin"
+	HasPassword      bool       `json:"has_password"`   // false for accounts created through single sign-on
+	TwoFactorEnabled bool       `db:"totp_enabled" json:"two_factor_enabled"`
+	PendingEmail     string     `json:"pending_email,omitempty"` // waiting for confirmation after PATCH /me
+	CreatedAt        time.Time  `db:"created_at" json:"created_at"`
 }
@@ -2,18 +2,68 @@ import axios from "axios";
 
 const API_BASE = "http://localhost:8080"; // backend
 
+export const saveTokens = (data) => {
+  // Save JWT and the refresh token used to renew it
+  localStorage.setItem("token", data.token);
+  localStorage.setItem("refresh_token", data.refresh_token);
+};
+
+// Resolves to { two_factor_required, challenge_token } when the account uses
+// 2FA; finish with verifyTwoFactor.
 export const login = async (username, password) => {
   const res = await axios.post(`${API_BASE}/login`, { username, password });
-  // Save JWT
-  localStorage.setItem("token", res.data.token);
+  if (!res.data.two_factor_required) saveTokens(res.data);
+  return res.data;
+};
+
+// Accepts either an authenticator code or a recovery code
+export const verifyTwoFactor = async (challengeToken, code) => {
+  const isRecovery = code.replace(/[\s-]/g, "").length !== 6;
+  const res = await axios.post(`${API_BASE}/login/2fa`, {
+    challenge_token: challengeToken,
+    ...(isRecovery ? { recovery_code: code } : { code }),
+  });
+  saveTokens(res.data);
   return res.data;
 };
 
+// Single sign-on is a full-page redirect through the backend, which comes
+// back to /login/oidc
+export const ssoLoginUrl = `${API_BASE}/auth/oidc/login`;
+
 export const register = async (username, password) => {
   const res = await axios.post(`${API_BASE}/register`, { username, password });
   return res.data;
 };
 
-export const logout = () => {
+export const logout = async () => {
+  const token = localStorage.getItem("token");
+  const refreshToken = localStorage.getItem("refresh_token");
   localStorage.removeItem("token");
+  localStorage.removeItem("refresh_token");
+  if (!token) return;
+  try {
+    await axios.post(
+      `${API_BASE}/logout`,
+      { refresh_token: refreshToken },
+      { headers: { Authorization: `Bearer ${token}` } }
+    );
+  } catch {
+    // Already logged out on the server
+  }
+};
+
+export const verifyEmail = async (token) => {
+  const res = await axios.post(`${API_BASE}/email/verify`, { token });
+  return res.data;
+};
+
+export const forgotPassword = async (email) => {
+  const res = await axios.post(`${API_BASE}/password/forgot`, { email });
+  return res.data;
+};
+
+export const resetPassword = async (token, password) => {
+  const res = await axios.post(`${API_BASE}/password/reset`, { token, password });
+  return res.data;
 };
@@ -12,13 +12,51 @@ const api = axios.create({
   baseURL: API_BASE,
 });
 
+// Refresh tokens rotate on every use, so concurrent 401s share one refresh
+let refreshing = null;
+function refreshTokens(refreshToken) {
+  if (!refreshing) {
+    refreshing = axios
+      .post(`${API_BASE}/token/refresh`, { refresh_token: refreshToken })
+      .then((res) => {
+        localStorage.setItem("token", res.data.token);
+        localStorage.setItem("refresh_token", res.data.refresh_token);
+        return res.data.token;
+      })
+      .finally(() => {
+        refreshing = null;
+      });
+  }
+  return refreshing;
+}
+
+// Access tokens are short-lived: on a 401, swap the refresh token for a new
+// pair once and retry the request before giving up.
 api.interceptors.response.use(
   (res) => res,
-  (err) => {
+  async (err) => {
+    const original = err.config;
     if (err.response && err.response.status === 401) {
+      const refreshToken = localStorage.getItem("refresh_token");
+      if (refreshToken && !original._retried) {
+        original._retried = true;
+        try {
+          const token = await refreshTokens(refreshToken);
+          original.headers.Authorization = `Bearer ${token}`;
+          return api(original);
+        } catch {
+          // Fall through to the login page
+        }
+      }
       localStorage.removeItem("token"); // clear old token
+      localStorage.removeItem("refresh_token");
       window.location.href = "/login"; // force redirect
     }
+    // Show the request ID with the message, so users can quote it to support
+    const data = err.response?.data;
+    if (data?.error && data.request_id) {
+      data.error = `${data.error} (request ID ${data.request_id})`;
+    }
     return Promise.reject(err);
   }
 );
@@ -131,4 +169,16 @@ export const deleteCategory = async (id) => {
     headers: { Authorization: `Bearer ${token}` },
   });
   return res.data;
-};
\ No newline at end of file
+};
+
+// Note images need the Authorization header like any other request, so they
+// are fetched here and shown through a blob URL instead of a plain <img src>.
+// path is the host-relative URL stored in note bodies, /notes/:id/images/:image_id
+export const getImage = async (path) => {
+  const token = getToken();
+  const res = await api.get(path, {
+    headers: { Authorization: `Bearer ${token}` },
+    responseType: "blob",
+  });
+  return res.data;
+};
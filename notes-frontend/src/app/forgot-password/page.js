@@ -0,0 +1,63 @@
+"use client";
+import { useState } from "react";
+import { useRouter } from "next/navigation";
+import { forgotPassword } from "@/api/auth";
+
+export default function ForgotPasswordPage() {
+  const [email, setEmail] = useState("");
+  const [error, setError] = useState("");
+  const [success, setSuccess] = useState("");
+  const router = useRouter();
+
+  async function handleSubmit(e) {
+    e.preventDefault();
+    setError("");
+    setSuccess("");
+
+    try {
+      const data = await forgotPassword(email);
+      setSuccess(data.message);
+    } catch (err) {
+      console.error(err);
+      setError(err.response?.data?.error || "Failed to send reset link");
+    }
+  }
+
+  return (
+    <div className="flex flex-col items-center justify-center min-h-screen bg-gray-100">
+      <form
+        onSubmit={handleSubmit}
+        className="bg-white p-6 rounded shadow-md w-80"
+      >
+        <h2 className="text-2xl font-bold mb-4 text-black">Forgot password</h2>
+
+        <input
+          type="email"
+          placeholder="Email"
+          value={email}
+          onChange={(e) => setEmail(e.target.value)}
+          required
+          className="w-full border px-3 py-2 rounded mb-4 text-black"
+        />
+
+        {error && <p className="text-red-500 text-sm mb-2">{error}</p>}
+        {success && <p className="text-green-500 text-sm mb-2">{success}</p>}
+
+        <button
+          type="submit"
+          className="w-full bg-blue-500 text-white py-2 rounded hover:bg-blue-600"
+        >
+          Send reset link
+        </button>
+
+        <button
+          type="button"
+          onClick={() => router.push("/login")}
+          className="w-full mt-3 border border-gray-400 py-2 rounded hover:bg-gray-100 text-black"
+        >
+          Back to Login
+        </button>
+      </form>
+    </div>
+  );
+}
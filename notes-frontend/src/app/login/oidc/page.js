@@ -0,0 +1,49 @@
+"use client";
+import { useEffect, useState } from "react";
+import { useRouter } from "next/navigation";
+import { saveTokens } from "@/api/auth";
+
+// The backend redirects here after single sign-on, with the outcome in the
+// URL fragment
+export default function OidcCallbackPage() {
+  const [error, setError] = useState("");
+  const router = useRouter();
+
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.hash.slice(1));
+    // Don't leave tokens in the browser history
+    window.history.replaceState(null, "", window.location.pathname);
+
+    if (params.get("error")) {
+      setError(params.get("error"));
+    } else if (params.get("token")) {
+      saveTokens({ token: params.get("token"), refresh_token: params.get("refresh_token") });
+      router.replace("/notes");
+    } else if (params.get("challenge_token")) {
+      sessionStorage.setItem("challenge_token", params.get("challenge_token"));
+      router.replace("/login");
+    } else if (params.get("linked")) {
+      router.replace("/notes");
+    } else {
+      setError("Single sign-on failed");
+    }
+  }, [router]);
+
+  return (
+    <div className="flex flex-col items-center justify-center min-h-screen bg-gray-100">
+      <div className="bg-white p-6 rounded shadow-md w-80">
+        <h2 className="text-2xl font-bold mb-4 text-black">Single sign-on</h2>
+        <p className="text-black mb-4">{error ? `❌ ${error}` : "Logging you in..."}</p>
+        {error && (
+          <button
+            type="button"
+            onClick={() => router.push("/login")}
+            className="w-full border border-gray-400 py-2 rounded hover:bg-gray-100 text-black"
+          >
+            Back to Login
+          </button>
+        )}
+      </div>
+    </div>
+  );
+}
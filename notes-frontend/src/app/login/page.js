@@ -1,20 +1,35 @@
 "use client";
-import { useState } from "react";
+import { useEffect, useState } from "react";
 import { useRouter } from "next/navigation";
-import { login } from "@/api/auth";
+import { login, ssoLoginUrl, verifyTwoFactor } from "@/api/auth";
 
 export default function LoginPage() {
   const [username, setUsername] = useState("");
   const [password, setPassword] = useState("");
   const [error, setError] = useState("");
+  const [challenge, setChallenge] = useState(null);
+  const [code, setCode] = useState("");
   const router = useRouter();
 
+  // Single sign-on hands over here when the account also uses 2FA
+  useEffect(() => {
+    const pending = sessionStorage.getItem("challenge_token");
+    if (pending) {
+      sessionStorage.removeItem("challenge_token");
+      setChallenge(pending);
+    }
+  }, []);
+
   async function handleLogin(e) {
     e.preventDefault();
     setError("");
 
     try {
-      await login(username, password);
+      const data = await login(username, password);
+      if (data.two_factor_required) {
+        setChallenge(data.challenge_token);
+        return;
+      }
       router.push("/notes"); // redirect after success
     } catch (err) {
       console.error(err);
@@ -22,6 +37,58 @@ export default function LoginPage() {
     }
   }
 
+  async function handleVerify(e) {
+    e.preventDefault();
+    setError("");
+
+    try {
+      await verifyTwoFactor(challenge, code);
+      router.push("/notes");
+    } catch (err) {
+      console.error(err);
+      if (err.response?.status === 401 && err.response.data?.error !== "Invalid code") {
+        // Challenge expired or too many attempts
+        setChallenge(null);
+        setCode("");
+        setError("Please log in again");
+      } else {
+        setError("Invalid code");
+      }
+    }
+  }
+
+  if (challenge) {
+    return (
+      <div className="flex flex-col items-center justify-center min-h-screen bg-gray-100">
+        <form
+          onSubmit={handleVerify}
+          className="bg-white p-6 rounded shadow-md w-80"
+        >
+          <h2 className="text-2xl font-bold mb-4 text-black">Two-factor code</h2>
+
+          <input
+            type="text"
+            inputMode="numeric"
+            autoComplete="one-time-code"
+            placeholder="Code or recovery code"
+            value={code}
+            onChange={(e) => setCode(e.target.value)}
+            className="w-full border px-3 py-2 rounded mb-4 text-black"
+          />
+
+          <button
+            type="submit"
+            className="w-full bg-blue-500 text-white py-2 rounded hover:bg-blue-600"
+          >
+            Verify
+          </button>
+
+          {error && <p className="text-red-500 mt-3 text-sm">{error}</p>}
+        </form>
+      </div>
+    );
+  }
+
   return (
     <div className="flex flex-col items-center justify-center min-h-screen bg-gray-100">
       <form
@@ -61,6 +128,22 @@ export default function LoginPage() {
         >
           Register
         </button>
+
+        <button
+          type="button"
+          onClick={() => (window.location.href = ssoLoginUrl)}
+          className="w-full mt-3 border border-gray-400 py-2 rounded hover:bg-gray-100 text-black"
+        >
+          Log in with SSO
+        </button>
+
+        <button
+          type="button"
+          onClick={() => router.push("/forgot-password")}
+          className="w-full mt-3 text-sm text-blue-500 hover:underline"
+        >
+          Forgot password?
+        </button>
       </form>
     </div>
   );
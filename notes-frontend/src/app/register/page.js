@@ -32,7 +32,9 @@ export default function RegisterPage() {
       }, 1500);
     } catch (err) {
       console.error(err);
-      setError("❌ Failed to register. Try another username or email.");
+      // 400s explain what to fix, such as a password that is too short
+      const message = err.response?.status === 400 && err.response.data?.error;
+      setError(message ? `❌ ${message}` : "❌ Failed to register. Try another username or email.");
     }
   }
 
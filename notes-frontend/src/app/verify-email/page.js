@@ -0,0 +1,39 @@
+"use client";
+import { useEffect, useState } from "react";
+import { useRouter } from "next/navigation";
+import { verifyEmail } from "@/api/auth";
+
+export default function VerifyEmailPage() {
+  const [status, setStatus] = useState("Verifying your email...");
+  const router = useRouter();
+
+  useEffect(() => {
+    const token = new URLSearchParams(window.location.search).get("token");
+    if (!token) {
+      setStatus("❌ This link is missing its token.");
+      return;
+    }
+    verifyEmail(token)
+      .then(() => setStatus("✅ Your email address is confirmed."))
+      .catch((err) => {
+        console.error(err);
+        setStatus(`❌ ${err.response?.data?.error || "Failed to verify email"}`);
+      });
+  }, []);
+
+  return (
+    <div className="flex flex-col items-center justify-center min-h-screen bg-gray-100">
+      <div className="bg-white p-6 rounded shadow-md w-80">
+        <h2 className="text-2xl font-bold mb-4 text-black">Email verification</h2>
+        <p className="text-black mb-4">{status}</p>
+        <button
+          type="button"
+          onClick={() => router.push("/login")}
+          className="w-full border border-gray-400 py-2 rounded hover:bg-gray-100 text-black"
+        >
+          Go to Login
+        </button>
+      </div>
+    </div>
+  );
+}
@@ -1,8 +1,36 @@
 "use client";
-import { useState } from "react";
-import { updateNote, deleteNote } from "@/api/notes";
+import { useEffect, useState } from "react";
+import { updateNote, deleteNote, getImage } from "@/api/notes";
 import ReactMarkdown from "react-markdown";
 
+// Images stored with the note live behind the API's auth; anything else
+// (external links, data URLs) is shown as is
+const isNoteImage = (src) => /^\/notes\/\d+\/images\/\d+$/.test(src || "");
+
+function NoteImage({ src, ...props }) {
+  const [blobUrl, setBlobUrl] = useState(null);
+
+  useEffect(() => {
+    if (!isNoteImage(src)) return;
+    let url = null;
+    let cancelled = false;
+    getImage(src)
+      .then((blob) => {
+        if (cancelled) return;
+        url = URL.createObjectURL(blob);
+        setBlobUrl(url);
+      })
+      .catch((err) => console.error("Failed to load image:", err.message));
+    return () => {
+      cancelled = true;
+      if (url) URL.revokeObjectURL(url);
+    };
+  }, [src]);
+
+  if (isNoteImage(src) && !blobUrl) return null;
+  return <img {...props} src={isNoteImage(src) ? blobUrl : src} />;
+}
+
 export default function NoteCard({ note, onUpdated }) {
   const [editing, setEditing] = useState(false);
   const [title, setTitle] = useState(note.title);
@@ -130,7 +158,7 @@ export default function NoteCard({ note, onUpdated }) {
             <ReactMarkdown
               components={{
                 img: ({ node, ...props }) => (
-                  <img
+                  <NoteImage
                     {...props}
                     alt={props.alt || "note image"}
                     className="rounded-md mt-2 max-h-64 object-contain"